@@ -0,0 +1,245 @@
+package wbot
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/twiny/wbot/pkg/api"
+)
+
+const (
+	checkpointQueue    = "queue"
+	checkpointInflight = "inflight"
+	checkpointStore    = "store"
+	checkpointMetrics  = "metrics"
+
+	checkpointRetries = 1000
+)
+
+// Resume creates a crawler from a checkpoint written by Checkpoint,
+// the crawl is continued by calling Run.
+func Resume(path string, opts ...Option) (*Crawler, error) {
+	c := New(opts...)
+
+	if err := c.restore(path); err != nil {
+		c.Shutdown()
+		return nil, fmt.Errorf("resume: %w", err)
+	}
+
+	return c, nil
+}
+
+// Checkpoint saves the frontier, the in-flight requests, the visited set
+// and the metrics into the directory at path, replacing any previous checkpoint.
+func (c *Crawler) Checkpoint(path string) error {
+	// block workers while the snapshot is taken, so every request
+	// is either queued, in-flight or done with its links queued.
+	if err := c.lockCheckpoint(); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	defer c.checkpointMu.Unlock()
+
+	tmp := path + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	if err := os.MkdirAll(tmp, 0o755); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+
+	files := []struct {
+		name    string
+		service any
+		require bool
+	}{
+		{checkpointQueue, c.queue, true},
+		{checkpointStore, c.store, true},
+		{checkpointMetrics, c.metrics, false},
+	}
+
+	for _, file := range files {
+		cp, ok := file.service.(api.Checkpointer)
+		if !ok {
+			if file.require {
+				return fmt.Errorf("checkpoint: %s does not support checkpoints", file.name)
+			}
+			c.logger.Warn().Msgf("checkpoint: %s does not support checkpoints", file.name)
+			continue
+		}
+
+		if err := writeCheckpointFile(filepath.Join(tmp, file.name), cp.Checkpoint); err != nil {
+			return fmt.Errorf("checkpoint %s: %w", file.name, err)
+		}
+	}
+
+	if err := writeCheckpointFile(filepath.Join(tmp, checkpointInflight), c.checkpointInflight); err != nil {
+		return fmt.Errorf("checkpoint %s: %w", checkpointInflight, err)
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+// lockCheckpoint waits for the requests popped by workers to be tracked,
+// a request is briefly held by a worker between the queue and the in-flight set.
+func (c *Crawler) lockCheckpoint() error {
+	for i := 0; i < checkpointRetries; i++ {
+		c.checkpointMu.Lock()
+
+		c.inflightMu.Lock()
+		inflight := len(c.inflight) + len(c.held)
+		c.inflightMu.Unlock()
+
+		// sitemaps being discovered are pending, but not checkpointed.
+		tracked := int64(c.queue.Len()) + int64(inflight) + atomic.LoadInt64(&c.sitemap.active)
+
+		pending := atomic.LoadInt64(&c.pending)
+		if pending <= 0 || tracked >= pending {
+			return nil
+		}
+
+		c.checkpointMu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	return fmt.Errorf("pending requests are not tracked")
+}
+func (c *Crawler) restore(path string) error {
+	files := []struct {
+		name    string
+		service any
+	}{
+		{checkpointQueue, c.queue},
+		{checkpointStore, c.store},
+		{checkpointMetrics, c.metrics},
+	}
+
+	for _, file := range files {
+		f, err := os.Open(filepath.Join(path, file.name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		cp, ok := file.service.(api.Checkpointer)
+		if !ok {
+			f.Close()
+			return fmt.Errorf("%s does not support checkpoints", file.name)
+		}
+
+		queued := c.queue.Len()
+		err = cp.Restore(bufio.NewReader(f))
+		f.Close()
+
+		// restored requests are pending work.
+		atomic.AddInt64(&c.pending, int64(c.queue.Len()-queued))
+
+		if err != nil {
+			return fmt.Errorf("restore %s: %w", file.name, err)
+		}
+	}
+
+	// requests that were being fetched go back into the frontier.
+	f, err := os.Open(filepath.Join(path, checkpointInflight))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		req := new(api.Request)
+		if err := dec.Decode(req); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("restore %s: %w", checkpointInflight, err)
+		}
+
+		if err := c.push(req); err != nil {
+			return fmt.Errorf("restore %s: %w", checkpointInflight, err)
+		}
+	}
+}
+func (c *Crawler) checkpointInflight(w io.Writer) error {
+	c.inflightMu.Lock()
+	defer c.inflightMu.Unlock()
+
+	enc := json.NewEncoder(w)
+	for req := range c.inflight {
+		if err := enc.Encode(req); err != nil {
+			return err
+		}
+	}
+
+	// a held request is fetched again on resume, for its callback
+	// to enqueue its requests again.
+	for req := range c.held {
+		if _, found := c.inflight[req]; found {
+			continue
+		}
+		if err := enc.Encode(req); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+func (c *Crawler) autoCheckpoint(path string, interval time.Duration) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-c.flare.Done():
+			return
+		case <-ticker.C:
+			if err := c.Checkpoint(path); err != nil {
+				c.logger.Err(err).Msgf("checkpoint")
+				continue
+			}
+			c.logger.Debug().Str("path", path).Msgf("checkpoint saved")
+		}
+	}
+}
+
+func writeCheckpointFile(name string, fn func(io.Writer) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	bw := bufio.NewWriter(f)
+	if err := fn(bw); err != nil {
+		return err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
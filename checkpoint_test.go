@@ -0,0 +1,168 @@
+package wbot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/twiny/wbot/pkg/api"
+)
+
+// testFetcher serves a fixed set of pages, and blocks on the page
+// block until its context is done. like a HTTP fetcher, it fails
+// once its context is done.
+type testFetcher struct {
+	mu      sync.Mutex
+	pages   map[string][]string
+	block   string
+	blocked chan struct{}
+	fetched []string
+}
+
+func (f *testFetcher) Fetch(ctx context.Context, req *api.Request) (*api.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	path := req.Target.URL.Path
+	if path == f.block {
+		close(f.blocked)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	links, found := f.pages[path]
+	if !found {
+		return &api.Response{URL: req.Target, Status: 404}, nil
+	}
+
+	var next []*api.ParsedURL
+	for _, link := range links {
+		target, err := api.NewURL("https://example.com" + link)
+		if err != nil {
+			return nil, err
+		}
+		next = append(next, target)
+	}
+
+	f.mu.Lock()
+	f.fetched = append(f.fetched, path)
+	f.mu.Unlock()
+
+	return &api.Response{URL: req.Target, Status: 200, NextURLs: next}, nil
+}
+func (f *testFetcher) Close() error {
+	return nil
+}
+
+func TestCheckpointResume(t *testing.T) {
+	pages := map[string][]string{
+		"/":  {"/1", "/2", "/3"},
+		"/1": {"/4"},
+		"/2": {"/5"},
+		"/3": nil,
+		"/4": nil,
+		"/5": nil,
+	}
+
+	tests := []struct {
+		name     string
+		block    string
+		shutdown bool
+	}{
+		{"in-flight seed", "/", false},
+		{"in-flight page", "/2", false},
+		{"in-flight leaf", "/4", false},
+		{"shutdown in-flight seed", "/", true},
+		{"shutdown in-flight page", "/2", true},
+		{"shutdown in-flight leaf", "/4", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "checkpoint")
+			opts := []Option{
+				WithParallel(1),
+				WithRateLimit(&api.RateLimit{Hostname: "*", Rate: "1000/1s"}),
+				WithLogLevel(zerolog.Disabled),
+			}
+
+			before := &testFetcher{pages: pages, block: tt.block, blocked: make(chan struct{})}
+			beforeOpts := []Option{WithFetcher(before)}
+			if tt.shutdown {
+				beforeOpts = append(beforeOpts, WithCheckpoint(path, 0))
+			}
+			bot := New(append(opts, beforeOpts...)...)
+
+			done := make(chan error, 1)
+			go func() {
+				done <- bot.Run("https://example.com/")
+			}()
+
+			select {
+			case <-before.blocked:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s was never fetched", tt.block)
+			}
+
+			if !tt.shutdown {
+				if err := bot.Checkpoint(path); err != nil {
+					t.Fatalf("checkpoint: %v", err)
+				}
+			}
+			bot.Shutdown()
+
+			if err := <-done; err != nil {
+				t.Fatalf("run: %v", err)
+			}
+
+			// the checkpoint of the shutdown is written once fetches stop.
+			waitTestFile(t, path)
+
+			after := &testFetcher{pages: pages}
+			resumed, err := Resume(path, append(opts, WithFetcher(after))...)
+			if err != nil {
+				t.Fatalf("resume: %v", err)
+			}
+			defer resumed.Shutdown()
+
+			if err := resumed.Run(); err != nil {
+				t.Fatalf("run resumed: %v", err)
+			}
+
+			// every page is fetched once, the in-flight one again on resume.
+			got := append(before.fetched, after.fetched...)
+			sort.Strings(got)
+
+			want := []string{"/", "/1", "/2", "/3", "/4", "/5"}
+			if len(got) != len(want) {
+				t.Fatalf("fetched %v, want %v", got, want)
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("fetched %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func waitTestFile(t *testing.T, path string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("%s was never written", path)
+}
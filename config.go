@@ -5,6 +5,9 @@ import (
 	"time"
 
 	"github.com/twiny/poxa"
+
+	"github.com/twiny/wbot/pkg/api"
+	"github.com/twiny/wbot/pkg/services/proxy"
 )
 
 const (
@@ -22,7 +25,19 @@ type (
 		timeout     time.Duration
 		userAgents  poxa.Spinner[string]
 		referrers   poxa.Spinner[string]
-		proxies     poxa.Spinner[string]
+		proxies     api.ProxyPool
+		scorer      func(*api.Request) float64
+		scope       api.Scope
+		seedScopes  map[string]api.Scope
+		redirect    *api.RedirectPolicy
+
+		maxExternalHops int32
+
+		skipNofollowLinks bool
+		canonicalDedup    bool
+
+		checkpointPath     string
+		checkpointInterval time.Duration
 	}
 )
 
@@ -39,6 +54,9 @@ func newConfig(maxDepth int32, userAgents, referrers, proxies []string) *config
 		userAgents:  poxa.NewSpinner(defaultUserAgent),
 		referrers:   poxa.NewSpinner(defaultReferrer),
 		proxies:     nil,
+		scope:       api.DomainScope(),
+		seedScopes:  make(map[string]api.Scope),
+		redirect:    &api.RedirectPolicy{MaxRedirects: defaultMaxRedirects},
 	}
 
 	if len(userAgents) > 0 {
@@ -56,10 +74,7 @@ func newConfig(maxDepth int32, userAgents, referrers, proxies []string) *config
 	}
 
 	if len(proxies) > 0 {
-		proxyList := poxa.NewSpinner(proxies...)
-		if proxyList != nil {
-			conf.proxies = proxyList
-		}
+		conf.proxies = proxy.NewPool(proxies)
 	}
 
 	return conf
@@ -2,10 +2,11 @@ package wbot
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,11 +21,6 @@ import (
 	"github.com/twiny/wbot/pkg/services/store"
 )
 
-const (
-	crawlRunning = 0
-	crawlStopped = 1
-)
-
 type (
 	Crawler struct {
 		wg  *sync.WaitGroup
@@ -38,17 +34,44 @@ type (
 		filter  *filter
 		limiter *rateLimiter
 		robot   *robotManager
+		sitemap *sitemapManager
+		retrier *retrier
+
+		// handlers counts the OnReponse callbacks, a response is held
+		// in the crawl until delivered, so callbacks can enqueue requests.
+		stream   chan *delivery
+		handlers int32
+
+		checkpointMu sync.RWMutex
+		inflightMu   sync.Mutex
+		inflight     map[*api.Request]struct{}
+
+		// held are the requests whose response awaits its callback,
+		// they are checkpointed with the in-flight requests.
+		held map[*api.Request]struct{}
 
-		stream chan *api.Response
+		// pending counts the requests that are queued or in-flight,
+		// the crawl is over once it drops to zero. endMu orders the end
+		// of the crawl with the requests enqueued from outside of it.
+		pending int64
+		endMu   sync.Mutex
+		flare   flare.Notifier
 
-		status int32
-		flare  flare.Notifier
+		// paused is open while the crawl is paused, and closed on resume.
+		pauseMu sync.Mutex
+		paused  chan struct{}
 
 		logger zerolog.Logger
 
 		ctx  context.Context
 		stop context.CancelFunc
 	}
+
+	delivery struct {
+		req  *api.Request
+		resp *api.Response
+		held bool
+	}
 )
 
 func New(opts ...Option) *Crawler {
@@ -68,16 +91,18 @@ func New(opts ...Option) *Crawler {
 
 		fetcher: fetcher.NewHTTPClient(),
 		store:   store.NewInMemoryStore(),
-		queue:   queue.NewInMemoryQueue(2048),
 		metrics: metrics.NewMetricsMonitor(),
 
 		filter:  newFilter(),
 		limiter: newRateLimiter(),
-		robot:   newRobotManager(false),
+		sitemap: newSitemapManager(),
+		retrier: newRetrier(nil),
 
-		stream: make(chan *api.Response, 1024),
+		stream: make(chan *delivery, 1024),
+
+		inflight: make(map[*api.Request]struct{}),
+		held:     make(map[*api.Request]struct{}),
 
-		status: crawlStopped,
 		flare:  flare.New(),
 		logger: logger,
 
@@ -85,15 +110,34 @@ func New(opts ...Option) *Crawler {
 		stop: stop,
 	}
 
+	c.robot = newRobotManager(false, c.fetch, func(host string, delay time.Duration) {
+		c.limiter.setCrawlDelay(host, delay)
+	})
+
+	// the default frontier paces each host by its rate limit,
+	// so workers are only handed requests they can fetch right away.
+	c.queue = queue.NewHostQueue(2048, func(req *api.Request) time.Duration {
+		return c.limiter.interval(req.Target)
+	})
+
 	for _, opt := range opts {
 		opt(c)
 	}
 
+	// requests left in a persistent queue.
+	c.pending = int64(c.queue.Len())
+
 	// this routine waits for quit signal
 	go func() {
 		<-c.ctx.Done()
 		c.logger.Info().Msgf("Crawler is shutting down")
 
+		if c.cfg.checkpointPath != "" {
+			if err := c.Checkpoint(c.cfg.checkpointPath); err != nil {
+				c.logger.Err(err).Msgf("checkpoint")
+			}
+		}
+
 		c.flare.Cancel()
 		c.queue.Close()
 		c.store.Close()
@@ -101,51 +145,129 @@ func New(opts ...Option) *Crawler {
 
 		c.wg.Wait()
 		close(c.stream)
+
+		// responses left undelivered by the shutdown.
+		for d := range c.stream {
+			discard(d.resp)
+		}
 	}()
 
 	return c
 }
 
 func (c *Crawler) Run(links ...string) error {
-	var (
-		targets []*api.ParsedURL
-		errs    []error
-	)
-
-	for _, link := range links {
-		target, err := api.NewURL(link)
-		if err != nil {
-			errs = append(errs, err)
-			continue
-		}
-		targets = append(targets, target)
-	}
-
-	if len(errs) > 0 {
-		return fmt.Errorf("invalid links: %v", errs)
+	targets, err := parseLinks(links...)
+	if err != nil {
+		return err
 	}
 
-	if len(targets) == 0 {
+	// a resumed crawler can run from its restored frontier alone.
+	if len(targets) == 0 && c.queue.Len() == 0 {
 		return fmt.Errorf("no valid links")
 	}
 
+	// hold the crawl open until all the seeds are queued.
+	atomic.AddInt64(&c.pending, 1)
 	for _, target := range targets {
-		c.add(target)
+		if err := c.enqueue(c.newRequest(target), false); err != nil {
+			c.logger.Err(err).Any("target", target.String()).Msgf("push")
+		}
 	}
-
-	c.status = crawlRunning
+	c.finish()
 
 	c.logger.Info().Msgf("Starting crawler with %d links", len(targets))
 
+	if c.cfg.checkpointPath != "" && c.cfg.checkpointInterval > 0 {
+		c.wg.Add(1)
+		go c.autoCheckpoint(c.cfg.checkpointPath, c.cfg.checkpointInterval)
+	}
+
+	// workers blocked on the queue are released once the crawl is over.
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+
+	go func() {
+		<-c.flare.Done()
+		cancel()
+	}()
+
 	c.wg.Add(c.cfg.parallel)
 	for i := 0; i < c.cfg.parallel; i++ {
-		go c.crawl(i)
+		go c.crawl(ctx, i)
 	}
 
 	c.wg.Wait()
 	return nil
 }
+
+// Enqueue adds links to the crawl as new seeds, it is safe to call
+// while the crawler is running.
+func (c *Crawler) Enqueue(ctx context.Context, links ...string) error {
+	targets, err := parseLinks(links...)
+	if err != nil {
+		return err
+	}
+
+	var reqs []*api.Request
+	for _, target := range targets {
+		reqs = append(reqs, c.newRequest(target))
+	}
+
+	return c.enqueueAll(ctx, false, reqs)
+}
+
+// EnqueueRequest adds requests to the crawl, a request without
+// Param gets the crawler defaults. requests are deduplicated by
+// their method, target and body, visited ones are skipped.
+func (c *Crawler) EnqueueRequest(ctx context.Context, reqs ...*api.Request) error {
+	return c.enqueueAll(ctx, true, reqs)
+}
+func (c *Crawler) enqueueAll(ctx context.Context, dedup bool, reqs []*api.Request) error {
+	var errs []error
+
+	for _, req := range reqs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if req.Target == nil {
+			errs = append(errs, fmt.Errorf("request without target"))
+			continue
+		}
+
+		if req.Param == nil {
+			req.Param = c.newRequest(req.Target).Param
+		}
+
+		if req.Seed == nil {
+			req.Seed = req.Target
+		}
+
+		if req.Priority == 0 {
+			c.score(req)
+		}
+
+		if err := c.enqueue(req, dedup); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", req.Target.String(), err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("enqueue: %v", errs)
+	}
+
+	return nil
+}
 func (c *Crawler) OnReponse(fn func(*api.Response)) {
+	atomic.AddInt32(&c.handlers, 1)
+
+	deliver := func(d *delivery) {
+		fn(d.resp)
+		if d.held {
+			c.release(d.req)
+		}
+	}
+
 	c.wg.Add(1)
 	go func() {
 		defer c.wg.Done()
@@ -154,10 +276,21 @@ func (c *Crawler) OnReponse(fn func(*api.Response)) {
 			case <-c.ctx.Done():
 				return
 			case <-c.flare.Done():
-				return
-			case resp, ok := <-c.stream:
+				// deliver the responses sent before the crawl ended.
+				for {
+					select {
+					case d, ok := <-c.stream:
+						if !ok {
+							return
+						}
+						deliver(d)
+					default:
+						return
+					}
+				}
+			case d, ok := <-c.stream:
 				if ok {
-					fn(resp)
+					deliver(d)
 				}
 			}
 		}
@@ -166,126 +299,512 @@ func (c *Crawler) OnReponse(fn func(*api.Response)) {
 func (c *Crawler) Metrics() map[string]int64 {
 	return c.metrics.Metrics()
 }
+
+// Pause stops workers from fetching new requests, requests being
+// fetched complete and the frontier is kept until Resume.
+func (c *Crawler) Pause() {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+
+	if c.paused == nil {
+		c.paused = make(chan struct{})
+		c.logger.Info().Msgf("Crawler is paused")
+	}
+}
+func (c *Crawler) Resume() {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+
+	if c.paused != nil {
+		close(c.paused)
+		c.paused = nil
+		c.logger.Info().Msgf("Crawler is resumed")
+	}
+}
 func (c *Crawler) Shutdown() {
 	c.stop()
 }
 
-func (c *Crawler) add(target *api.ParsedURL) {
+func (c *Crawler) newRequest(target *api.ParsedURL) *api.Request {
 	param := &api.Param{
-		MaxBodySize: c.cfg.maxBodySize,
-		UserAgent:   c.cfg.userAgents.Next(),
-		Timeout:     c.cfg.timeout,
-	}
+		MaxBodySize:  c.cfg.maxBodySize,
+		UserAgent:    c.cfg.userAgents.Next(),
+		Timeout:      c.cfg.timeout,
+		FollowRobots: c.robot.followRobots,
 
-	if c.cfg.proxies != nil {
-		param.Proxy = c.cfg.proxies.Next()
+		SkipNofollowLinks: c.cfg.skipNofollowLinks,
 	}
 
 	req := &api.Request{
 		Target: target,
+		Seed:   target,
 		Param:  param,
 		Depth:  0,
 	}
+	c.score(req)
+
+	return req
+}
+
+// enqueue pushes a seed request, seeds are marked as visited
+// so links pointing back to them are not crawled twice.
+// with dedup, a request already visited is skipped.
+func (c *Crawler) enqueue(req *api.Request, dedup bool) error {
+	c.checkpointMu.RLock()
+	defer c.checkpointMu.RUnlock()
+
+	// the crawl can not end between the check and the push.
+	c.endMu.Lock()
+	select {
+	case <-c.flare.Done():
+		c.endMu.Unlock()
+		return fmt.Errorf("crawler is stopped")
+	default:
+	}
+	atomic.AddInt64(&c.pending, 1)
+	c.endMu.Unlock()
+	defer c.finish()
+
+	visited, err := c.store.HasVisited(c.ctx, req.Identity())
+	if err != nil {
+		c.logger.Err(err).Msgf("store")
+	}
+
+	if visited && dedup {
+		c.metrics.IncDuplicatedLink()
+		return nil
+	}
+
+	if err := c.push(req); err != nil {
+		return err
+	}
+
+	c.discoverSitemaps(req)
+
+	return nil
+}
+func (c *Crawler) push(req *api.Request) error {
+	atomic.AddInt64(&c.pending, 1)
 
 	if err := c.queue.Push(c.ctx, req); err != nil {
-		c.logger.Err(err).Msgf("pop")
-		return
+		c.finish()
+		return err
+	}
+
+	return nil
+}
+
+// finish marks a pending request as done, and ends the crawl
+// when no request is left queued or in-flight.
+func (c *Crawler) finish() {
+	c.endMu.Lock()
+	defer c.endMu.Unlock()
+
+	if atomic.AddInt64(&c.pending, -1) == 0 {
+		c.flare.Cancel()
+	}
+}
+func parseLinks(links ...string) ([]*api.ParsedURL, error) {
+	var (
+		targets []*api.ParsedURL
+		errs    []error
+	)
+
+	for _, link := range links {
+		target, err := api.NewURL(link)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		targets = append(targets, target)
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid links: %v", errs)
+	}
+
+	return targets, nil
+}
+
+// inScope checks target against the scope of the seed req was found from.
+func (c *Crawler) inScope(req *api.Request, target *api.ParsedURL) bool {
+	seed := req.Seed
+	if seed == nil {
+		seed = req.Target
+	}
+
+	scope, found := c.cfg.seedScopes[seed.Hash]
+	if !found {
+		scope = c.cfg.scope
+	}
+
+	return scope.Contains(seed, target)
+}
+func (c *Crawler) score(req *api.Request) {
+	if c.cfg.scorer != nil {
+		req.Priority = c.cfg.scorer(req)
+	}
+}
+
+// parked blocks while the crawl is paused,
+// it returns false if ctx is done in the meantime.
+func (c *Crawler) parked(ctx context.Context) bool {
+	c.pauseMu.Lock()
+	paused := c.paused
+	c.pauseMu.Unlock()
+
+	if paused == nil {
+		return true
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-paused:
+		return true
 	}
 }
-func (c *Crawler) crawl(id int) {
+func (c *Crawler) track(req *api.Request) {
+	c.inflightMu.Lock()
+	defer c.inflightMu.Unlock()
+
+	c.inflight[req] = struct{}{}
+}
+func (c *Crawler) untrack(req *api.Request) {
+	c.inflightMu.Lock()
+	defer c.inflightMu.Unlock()
+
+	delete(c.inflight, req)
+}
+
+// hold keeps req pending until its response is delivered to a callback,
+// so the crawl does not end before the callback can enqueue requests.
+func (c *Crawler) hold(req *api.Request) bool {
+	if atomic.LoadInt32(&c.handlers) == 0 {
+		return false
+	}
+
+	c.checkpointMu.RLock()
+	defer c.checkpointMu.RUnlock()
+
+	c.inflightMu.Lock()
+	c.held[req] = struct{}{}
+	c.inflightMu.Unlock()
+
+	atomic.AddInt64(&c.pending, 1)
+	return true
+}
+func (c *Crawler) release(req *api.Request) {
+	c.checkpointMu.RLock()
+	defer c.checkpointMu.RUnlock()
+
+	c.inflightMu.Lock()
+	delete(c.held, req)
+	c.inflightMu.Unlock()
+
+	c.finish()
+}
+func (c *Crawler) pop(ctx context.Context) (*api.Request, error) {
+	req, err := c.queue.PopWait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.checkpointMu.RLock()
+	defer c.checkpointMu.RUnlock()
+
+	c.track(req)
+
+	return req, nil
+}
+func (c *Crawler) done(req *api.Request) {
+	c.checkpointMu.RLock()
+	defer c.checkpointMu.RUnlock()
+
+	c.untrack(req)
+	c.finish()
+}
+func (c *Crawler) crawl(ctx context.Context, id int) {
 	defer c.wg.Done()
 
 	for {
-		select {
-		case <-c.ctx.Done():
+		if !c.parked(ctx) {
 			return
-		default:
-			if atomic.LoadInt32(&c.status) == crawlStopped && c.queue.Len() == 0 {
+		}
+
+		req, err := c.pop(ctx)
+		switch {
+		case err == nil:
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, api.ErrQueueClosed):
+			// a queue failed under a running crawl ends it.
+			if c.ctx.Err() == nil {
+				c.logger.Err(err).Msgf("pop")
 				c.flare.Cancel()
-				return
 			}
+			return
+		case errors.Is(err, api.ErrQueueCorrupt):
+			// the request dropped by the queue is done.
+			c.logger.Err(err).Msgf("pop")
+			c.finish()
+			continue
+		default:
+			c.logger.Err(err).Msgf("pop")
+			continue
+		}
 
-			// not elegant, but just to give the queue some time to fill up
-			if atomic.LoadInt32(&c.status) == crawlRunning && c.queue.Len() == 0 {
-				<-time.After(1 * time.Second)
-				continue
-			}
+		// the crawl may have been paused while waiting on the queue,
+		// the request stays in-flight until the crawl is resumed.
+		if !c.parked(ctx) {
+			return
+		}
+		c.metrics.IncTotalRequests()
 
-			req, err := c.queue.Pop(c.ctx)
-			if err != nil {
-				c.logger.Err(err).Msgf("pop")
-				continue
-			}
-			c.metrics.IncTotalRequests()
+		// a robots.txt fetch cut by the shutdown is no verdict.
+		allowed := c.robot.allowed(ctx, req)
+		if c.ctx.Err() != nil {
+			return
+		}
 
-			// if the next response will exceed the max depth,
-			// we signal the crawler to stop
-			if atomic.LoadInt32(&req.Depth) > c.cfg.maxDepth-1 {
-				atomic.StoreInt32(&c.status, crawlStopped)
-			}
+		if !allowed {
+			c.metrics.IncSkippedLink()
+			c.logger.Debug().Any("target", req.Target.String()).Msgf("disallowed by robots.txt")
+			c.done(req)
+			continue
+		}
 
-			c.limiter.wait(req.Target)
+		fetchReq, marked := c.withRedirects(req)
+		resp, err := c.fetch(c.ctx, fetchReq)
+		if delay, ok := c.retrier.delay(req, resp, err); ok {
+			c.metrics.IncFailedRequests()
+			discard(resp)
+			c.retry(req, delay)
+			continue
+		}
 
-			resp, err := c.fetcher.Fetch(c.ctx, req)
-			if err != nil {
-				c.metrics.IncFailedRequests()
-				c.logger.Err(err).Any("target", req.Target.String()).Msgf("fetch")
-				continue
-			}
+		// a fetch cut by the shutdown stays in-flight, so the
+		// checkpoint taken on shutdown fetches it again on resume.
+		if err != nil && c.ctx.Err() != nil {
+			return
+		}
 
-			c.stream <- resp
-			c.metrics.IncSuccessfulRequests()
+		if err != nil {
+			c.metrics.IncFailedRequests()
+			c.logger.Err(err).Any("target", req.Target.String()).Msgf("fetch")
+			c.done(req)
+			continue
+		}
 
-			c.logger.Debug().Any("target", req.Target.String()).Msgf("fetched")
+		if c.visitFinal(req, resp, marked) {
+			c.metrics.IncDuplicatedLink()
+			c.logger.Debug().Any("target", req.Target.String()).Msgf("redirect target already visited")
+			discard(resp)
+			c.done(req)
+			continue
+		}
 
-			// increment the depth for the next requests
-			nextDepth := atomic.AddInt32(&req.Depth, 1)
+		if c.duplicate(req, resp) {
+			c.metrics.IncDuplicatedLink()
+			c.logger.Debug().Any("target", req.Target.String()).Msgf("canonical already visited")
+			discard(resp)
+			c.done(req)
+			continue
+		}
 
-			if nextDepth > c.cfg.maxDepth {
-				continue
-			}
+		if atomic.LoadInt32(&c.handlers) > 0 {
+			c.stream <- &delivery{req: req, resp: resp, held: c.hold(req)}
+		} else {
+			discard(resp)
+		}
+		c.metrics.IncSuccessfulRequests()
 
-			// logging here will just flood the logs
-			for _, target := range resp.NextURLs {
-				c.metrics.IncTotalLink()
+		c.logger.Debug().Any("target", req.Target.String()).Msgf("fetched")
 
-				if !strings.Contains(target.URL.Host, req.Target.Root) {
-					c.metrics.IncSkippedLink()
-					continue
-				}
+		c.follow(req, resp)
+	}
+}
 
-				if !c.robot.Allowed(req.Param.UserAgent, req.Target.URL.String()) {
-					c.metrics.IncSkippedLink()
-					// todo: log
-					continue
-				}
+// duplicate reports whether the canonical URL of resp, when it differs
+// from the URL the page was served from, was already visited.
+func (c *Crawler) duplicate(req *api.Request, resp *api.Response) bool {
+	// the canonical URL of a page is only meaningful for GET requests.
+	if !c.cfg.canonicalDedup || req.HTTPMethod() != http.MethodGet {
+		return false
+	}
 
-				if !c.filter.allow(target) {
-					c.metrics.IncSkippedLink()
-					continue
-				}
+	page := req.Target
+	if resp.FinalURL != nil {
+		page = resp.FinalURL
+	}
 
-				if visited, err := c.store.HasVisited(c.ctx, target); visited {
-					if err != nil {
-						c.logger.Err(err).Msgf("store")
-					}
-					c.metrics.IncDuplicatedLink()
-					continue
-				}
+	if resp.Canonical == nil || resp.Canonical.Hash == page.Hash {
+		return false
+	}
 
-				nextReq := &api.Request{
-					Target: target,
-					Depth:  req.Depth,
-					Param:  req.Param,
-				}
+	visited, err := c.store.HasVisited(c.ctx, resp.Canonical)
+	if err != nil {
+		c.logger.Err(err).Msgf("store")
+	}
 
-				if err := c.queue.Push(c.ctx, nextReq); err != nil {
-					c.logger.Err(err).Any("target", target.String()).Msgf("push")
-					continue
-				}
+	return visited
+}
 
-				c.metrics.IncCrawledLink()
-			}
+// fetch waits for the rate limit of the host, and sends req through
+// a proxy of the pool unless it already has one.
+func (c *Crawler) fetch(ctx context.Context, req *api.Request) (*api.Response, error) {
+	c.limiter.wait(req.Target)
+
+	if c.cfg.proxies == nil || req.Param.Proxy != "" {
+		return c.fetcher.Fetch(ctx, req)
+	}
+
+	param := *req.Param
+	param.Proxy = c.cfg.proxies.Next(req.Target.URL.Host)
+	if param.Proxy == "" {
+		return c.fetcher.Fetch(ctx, req)
+	}
+
+	proxied := *req
+	proxied.Param = &param
+
+	start := time.Now()
+	resp, err := c.fetcher.Fetch(ctx, &proxied)
+
+	// a canceled crawl says nothing about the proxy.
+	if ctx.Err() == nil {
+		c.cfg.proxies.Report(param.Proxy, time.Since(start), proxyError(resp, err))
+	}
+
+	return resp, err
+}
+
+// proxyError reports the failures that are likely caused by the proxy,
+// a rejected authentication or a rate limited address.
+func proxyError(resp *api.Response, err error) error {
+	if err != nil {
+		return err
+	}
+
+	switch resp.Status {
+	case http.StatusProxyAuthRequired, http.StatusTooManyRequests:
+		return fmt.Errorf("proxy status: %d", resp.Status)
+	}
+	return nil
+}
+
+// readBody returns the body of an internal fetch, such as robots.txt or
+// a sitemap, reading it back when the fetcher streamed it to a file.
+func readBody(resp *api.Response) ([]byte, error) {
+	if resp.BodyFile == "" {
+		return resp.Body, nil
+	}
+	defer os.Remove(resp.BodyFile)
+
+	return os.ReadFile(resp.BodyFile)
+}
+
+// discard removes the body file of a response that is not delivered.
+func discard(resp *api.Response) {
+	if resp != nil && resp.BodyFile != "" {
+		os.Remove(resp.BodyFile)
+	}
+}
+
+// follow queues the links of resp, the request is marked done
+// once all of its links are queued.
+func (c *Crawler) follow(req *api.Request, resp *api.Response) {
+	c.checkpointMu.RLock()
+	defer c.checkpointMu.RUnlock()
+	defer c.finish()
+	defer c.untrack(req)
+
+	c.redirect(req, resp)
+
+	// only the sitemaps provide links in sitemap-only mode.
+	if c.sitemap.only {
+		return
+	}
+
+	if req.Depth+1 > c.cfg.maxDepth {
+		return
+	}
+
+	if req.Param.FollowRobots && resp.Robots.NoFollow {
+		return
+	}
+
+	// logging here will just flood the logs
+	for _, target := range resp.NextURLs {
+		c.link(req, target, nil)
+	}
+}
+
+// link queues target as found from req, if it passes the depth,
+// scope, robots.txt, filter and visited checks.
+func (c *Crawler) link(req *api.Request, target *api.ParsedURL, meta map[string]string) {
+	c.metrics.IncTotalLink()
+
+	// the depth for the next requests
+	nextDepth := req.Depth + 1
+
+	if nextDepth > c.cfg.maxDepth {
+		c.metrics.IncSkippedLink()
+		return
+	}
+
+	// links leaving the scope are followed up to maxExternalHops away.
+	var hops int32
+	if !c.inScope(req, target) {
+		hops = req.ExternalHops + 1
+		if hops > c.cfg.maxExternalHops {
+			c.metrics.IncSkippedLink()
+			return
+		}
+	}
+
+	// the robots.txt of new origins is checked once dispatched.
+	if !c.robot.cached(target, req.Param.UserAgent) {
+		c.metrics.IncSkippedLink()
+		return
+	}
+
+	if !c.filter.allow(target) {
+		c.metrics.IncSkippedLink()
+		return
+	}
+
+	if visited, err := c.store.HasVisited(c.ctx, target); visited {
+		if err != nil {
+			c.logger.Err(err).Msgf("store")
 		}
+		c.metrics.IncDuplicatedLink()
+		return
+	}
+
+	// the header of a request is not sent out of its scope.
+	param := req.Param
+	if hops > 0 && param.Header != nil {
+		offScope := *param
+		offScope.Header = nil
+		param = &offScope
 	}
+
+	nextReq := &api.Request{
+		Target: target,
+		Seed:   req.Seed,
+		Depth:  nextDepth,
+		Param:  param,
+		Meta:   meta,
+
+		ExternalHops: hops,
+	}
+	c.score(nextReq)
+
+	if err := c.push(nextReq); err != nil {
+		c.logger.Err(err).Any("target", target.String()).Msgf("push")
+		return
+	}
+
+	c.metrics.IncCrawledLink()
 }
@@ -8,17 +8,15 @@ require (
 	github.com/temoto/robotstxt v1.1.2
 	github.com/twiny/flare v0.1.0
 	github.com/twiny/poxa v0.1.0
-	github.com/twiny/ratelimit v0.0.0-20220509163414-256d3376b0ac
 	github.com/weppos/publicsuffix-go v0.30.1
+	golang.org/x/net v0.17.0
+	golang.org/x/text v0.13.0
 )
 
 require (
 	github.com/andybalholm/cascadia v1.3.1 // indirect
-	github.com/benbjohnson/clock v1.3.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
 	github.com/stretchr/testify v1.8.0 // indirect
-	golang.org/x/net v0.17.0 // indirect
 	golang.org/x/sys v0.13.0 // indirect
-	golang.org/x/text v0.13.0 // indirect
 )
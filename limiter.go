@@ -3,9 +3,9 @@ package wbot
 import (
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/twiny/ratelimit"
 	"github.com/twiny/wbot/pkg/api"
 )
 
@@ -14,14 +14,29 @@ var (
 )
 
 type (
+	// rateLimiter books the fetch slots of each limiter. a domain rule
+	// paces the domain with its subdomains together, the "*" rule paces
+	// each other host on its own, like the host queue does. a host with
+	// a Crawl-delay stricter than its rule is paced alone.
 	rateLimiter struct {
-		table map[string]*ratelimit.Limiter
+		mu     *sync.Mutex
+		rules  map[string]*limitRule
+		slots  map[string]time.Time
+		delays map[string]time.Duration
+	}
+
+	limitRule struct {
+		rate     int
+		interval time.Duration
 	}
 )
 
 func newRateLimiter(limits ...*api.RateLimit) *rateLimiter {
 	rl := &rateLimiter{
-		table: make(map[string]*ratelimit.Limiter),
+		mu:     new(sync.Mutex),
+		rules:  make(map[string]*limitRule),
+		slots:  make(map[string]time.Time),
+		delays: make(map[string]time.Duration),
 	}
 
 	// Handle the default wildcard limit.
@@ -44,18 +59,74 @@ func newRateLimiter(limits ...*api.RateLimit) *rateLimiter {
 
 	for _, rate := range limits {
 		r, l := parseRateLimit(rate.Rate)
-		rl.table[rate.Hostname] = ratelimit.NewLimiter(r, l)
+		rl.rules[rate.Hostname] = &limitRule{
+			rate:     r,
+			interval: l,
+		}
 	}
 
 	return rl
 }
+
+// wait blocks until the next slot of the limiter of u.
 func (l *rateLimiter) wait(u *api.ParsedURL) {
-	limit, found := l.table[u.Root]
+	l.mu.Lock()
+	key, every := l.pace(u)
+	slot := l.next(key)
+	l.slots[key] = slot.Add(every)
+	l.mu.Unlock()
+
+	time.Sleep(time.Until(slot))
+}
+
+// interval returns how long the host queue holds the host of u after
+// handing it out, until the slot following the one its request takes,
+// so the queue follows the limiter instead of pacing the host again.
+func (l *rateLimiter) interval(u *api.ParsedURL) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key, every := l.pace(u)
+	return time.Until(l.next(key).Add(every))
+}
+
+// setCrawlDelay applies the robots.txt Crawl-delay of a host,
+// the stricter of the delay and the configured rate limit is used.
+func (l *rateLimiter) setCrawlDelay(host string, delay time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if delay <= 0 {
+		delete(l.delays, host)
+		return
+	}
+	l.delays[host] = delay
+}
+
+// next returns the next free slot of a limiter.
+func (l *rateLimiter) next(key string) time.Time {
+	now := time.Now()
+	if slot := l.slots[key]; slot.After(now) {
+		return slot
+	}
+	return now
+}
+
+// pace returns the limiter of u and the time between two of its requests.
+func (l *rateLimiter) pace(u *api.ParsedURL) (string, time.Duration) {
+	key := u.Root
+	rule, found := l.rules[key]
 	if !found {
-		limit = l.table["*"]
+		key = "*:" + u.URL.Host
+		rule = l.rules["*"]
 	}
 
-	limit.Take()
+	every := rule.interval / time.Duration(rule.rate)
+	if delay := l.delays[u.URL.Host]; delay > every {
+		return "host:" + u.URL.Host, delay
+	}
+
+	return key, every
 }
 
 func parseRateLimit(s string) (rate int, interval time.Duration) {
@@ -65,7 +136,7 @@ func parseRateLimit(s string) (rate int, interval time.Duration) {
 	}
 
 	rate, err := strconv.Atoi(parts[0])
-	if err != nil {
+	if err != nil || rate <= 0 {
 		return parseRateLimit(defaultRateLimit)
 	}
 
@@ -1,10 +1,13 @@
 package wbot
 
 import (
+	"time"
+
 	"github.com/rs/zerolog"
 	"github.com/twiny/poxa"
 
 	"github.com/twiny/wbot/pkg/api"
+	"github.com/twiny/wbot/pkg/services/proxy"
 )
 
 type (
@@ -21,6 +24,13 @@ func WithMaxDepth(maxDepth int32) Option {
 		c.cfg.maxDepth = maxDepth
 	}
 }
+
+// WithTimeout bounds each fetch, body included, 10s by default.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Crawler) {
+		c.cfg.timeout = timeout
+	}
+}
 func WithUserAgents(userAgents []string) Option {
 	return func(c *Crawler) {
 		c.cfg.userAgents = poxa.NewSpinner(userAgents...)
@@ -28,7 +38,12 @@ func WithUserAgents(userAgents []string) Option {
 }
 func WithProxies(proxies []string) Option {
 	return func(c *Crawler) {
-		c.cfg.proxies = poxa.NewSpinner(proxies...)
+		c.cfg.proxies = proxy.NewPool(proxies)
+	}
+}
+func WithProxyPool(pool api.ProxyPool) Option {
+	return func(c *Crawler) {
+		c.cfg.proxies = pool
 	}
 }
 func WithRateLimit(rates ...*api.RateLimit) Option {
@@ -36,11 +51,47 @@ func WithRateLimit(rates ...*api.RateLimit) Option {
 		c.limiter = newRateLimiter(rates...)
 	}
 }
+func WithRetry(policy *api.RetryPolicy) Option {
+	return func(c *Crawler) {
+		c.retrier = newRetrier(policy)
+	}
+}
+func WithRedirectPolicy(policy *api.RedirectPolicy) Option {
+	return func(c *Crawler) {
+		// a nil policy keeps the default one.
+		var p api.RedirectPolicy
+		if policy != nil {
+			p = *policy
+		}
+		if p.MaxRedirects <= 0 {
+			p.MaxRedirects = defaultMaxRedirects
+		}
+		c.cfg.redirect = &p
+	}
+}
 func WithFilter(rules ...*api.FilterRule) Option {
 	return func(c *Crawler) {
 		c.filter = newFilter(rules...)
 	}
 }
+func WithFollowRobots(follow bool) Option {
+	return func(c *Crawler) {
+		c.robot.followRobots = follow
+	}
+}
+func WithSitemaps(enabled bool) Option {
+	return func(c *Crawler) {
+		c.sitemap.enabled = enabled
+	}
+}
+func WithSitemapOnly(only bool) Option {
+	return func(c *Crawler) {
+		c.sitemap.only = only
+		if only {
+			c.sitemap.enabled = true
+		}
+	}
+}
 func WithFetcher(fetcher api.Fetcher) Option {
 	return func(c *Crawler) {
 		c.fetcher = fetcher
@@ -56,6 +107,47 @@ func WithQueue(queue api.Queue) Option {
 		c.queue = queue
 	}
 }
+func WithScorer(scorer func(*api.Request) float64) Option {
+	return func(c *Crawler) {
+		c.cfg.scorer = scorer
+	}
+}
+func WithScope(scope api.Scope) Option {
+	return func(c *Crawler) {
+		c.cfg.scope = scope
+	}
+}
+func WithSeedScope(link string, scope api.Scope) Option {
+	return func(c *Crawler) {
+		seed, err := api.NewURL(link)
+		if err != nil {
+			c.logger.Err(err).Str("link", link).Msgf("seed scope")
+			return
+		}
+		c.cfg.seedScopes[seed.Hash] = scope
+	}
+}
+func WithMaxExternalHops(hops int32) Option {
+	return func(c *Crawler) {
+		c.cfg.maxExternalHops = hops
+	}
+}
+func WithSkipNofollowLinks(skip bool) Option {
+	return func(c *Crawler) {
+		c.cfg.skipNofollowLinks = skip
+	}
+}
+func WithCanonicalDedup(enabled bool) Option {
+	return func(c *Crawler) {
+		c.cfg.canonicalDedup = enabled
+	}
+}
+func WithCheckpoint(path string, interval time.Duration) Option {
+	return func(c *Crawler) {
+		c.cfg.checkpointPath = path
+		c.cfg.checkpointInterval = interval
+	}
+}
 func WithLogLevel(level zerolog.Level) Option {
 	return func(c *Crawler) {
 		c.logger = c.logger.Level(level)
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+)
+
+type (
+	// Authenticator authenticates the requests sent to a host.
+	Authenticator interface {
+		Authenticate(req *http.Request) error
+	}
+
+	// AuthFunc is a custom Authenticator, e.g. a request signer.
+	AuthFunc func(req *http.Request) error
+
+	basicAuth struct {
+		username string
+		password string
+	}
+
+	bearerAuth struct {
+		token string
+	}
+)
+
+func (fn AuthFunc) Authenticate(req *http.Request) error {
+	return fn(req)
+}
+
+// BasicAuth sets the HTTP Basic credentials of a request.
+func BasicAuth(username, password string) Authenticator {
+	return &basicAuth{
+		username: username,
+		password: password,
+	}
+}
+func (a *basicAuth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+// BearerAuth sets a bearer token on a request.
+func BearerAuth(token string) Authenticator {
+	return &bearerAuth{
+		token: token,
+	}
+}
+func (a *bearerAuth) Authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
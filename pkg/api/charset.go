@@ -0,0 +1,215 @@
+package api
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+const (
+	// sniffSize is how much of a body is read to sniff its charset.
+	sniffSize = 64 << 10
+)
+
+var (
+	utf8BOM = []byte("\xef\xbb\xbf")
+)
+
+// DecodeBody detects the charset of a text body from its BOM, the
+// Content-Type header, a <meta charset> or by sniffing its bytes, and
+// returns the body transcoded to UTF-8 with the charset name.
+// binary bodies are returned as is, without charset.
+func DecodeBody(body []byte, contentType string) ([]byte, string) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+
+	if !IsTextType(mediaType) {
+		return body, ""
+	}
+
+	enc, name, certain := charset.DetermineEncoding(body, contentType)
+	if !certain && !declaresCharset(body) {
+		enc, name = sniffCharset(body)
+	}
+
+	if name == "utf-8" {
+		return bytes.TrimPrefix(body, utf8BOM), name
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return body, name
+	}
+
+	return decoded, name
+}
+
+// IsTextType reports whether a media type is textual,
+// e.g. text/plain, text/html or application/json.
+func IsTextType(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.Contains(mediaType, "html"),
+		strings.Contains(mediaType, "xml"),
+		strings.Contains(mediaType, "json"),
+		strings.Contains(mediaType, "javascript"):
+		return true
+	}
+	return false
+}
+
+// declaresCharset reports whether the head of a body declares its
+// charset, e.g. <meta charset="shift_jis">.
+func declaresCharset(body []byte) bool {
+	head := body[:min(len(body), 1024)]
+	return bytes.Contains(bytes.ToLower(head), []byte("charset"))
+}
+
+// sniffCharset guesses the charset of a body that does not declare one:
+// UTF-8 when it is valid UTF-8, then the Japanese, Korean and Chinese
+// encodings the body decodes in without error into text of their script,
+// then Cyrillic when most non-ASCII bytes follow each other, and
+// windows-1252 otherwise.
+func sniffCharset(body []byte) (encoding.Encoding, string) {
+	sample := body[:min(len(body), sniffSize)]
+
+	// cut the sample after its last ASCII byte, not to split a character.
+	if i := bytes.LastIndexFunc(sample, func(r rune) bool { return r < utf8.RuneSelf }); i >= 0 {
+		sample = sample[:i+1]
+	}
+
+	if utf8.Valid(sample) {
+		return encoding.Nop, "utf-8"
+	}
+
+	switch {
+	case scriptShare(sample, japanese.ShiftJIS, isKana) >= 0.2:
+		return japanese.ShiftJIS, "shift_jis"
+	case scriptShare(sample, japanese.EUCJP, isKana) >= 0.2:
+		return japanese.EUCJP, "euc-jp"
+	case scriptShare(sample, korean.EUCKR, isHangul) >= 0.7:
+		return korean.EUCKR, "euc-kr"
+	case scriptShare(sample, simplifiedchinese.GBK, isHan) >= 0.5 && pairShare(sample, isGB2312Pair) >= 0.9:
+		return simplifiedchinese.GBK, "gbk"
+	case scriptShare(sample, traditionalchinese.Big5, isHan) >= 0.5 && pairShare(sample, isBig5CommonPair) >= 0.5:
+		return traditionalchinese.Big5, "big5"
+	}
+
+	if lower, upper, ok := cyrillic(sample); ok {
+		// lowercase letters are 0xe0-0xff in windows-1251, 0xc0-0xdf in KOI8-R.
+		if lower >= upper {
+			return charmap.Windows1251, "windows-1251"
+		}
+		return charmap.KOI8R, "koi8-r"
+	}
+
+	return charmap.Windows1252, "windows-1252"
+}
+
+// scriptShare decodes sample with enc, and returns the share of its
+// non-ASCII characters in the script, 0 when sample is invalid in enc.
+func scriptShare(sample []byte, enc encoding.Encoding, inScript func(rune) bool) float64 {
+	decoded, err := enc.NewDecoder().Bytes(sample)
+	if err != nil {
+		return 0
+	}
+
+	var total, matched int
+	for _, r := range string(decoded) {
+		switch {
+		case r == utf8.RuneError:
+			return 0
+		case r < utf8.RuneSelf:
+			continue
+		case inScript(r):
+			matched++
+		}
+		total++
+	}
+
+	if total == 0 {
+		return 0
+	}
+	return float64(matched) / float64(total)
+}
+
+// pairShare returns the share of the double-byte characters of sample
+// that match fn.
+func pairShare(sample []byte, fn func(lead, trail byte) bool) float64 {
+	var total, matched int
+	for i := 0; i < len(sample); i++ {
+		if sample[i] < utf8.RuneSelf || i+1 == len(sample) {
+			continue
+		}
+
+		if fn(sample[i], sample[i+1]) {
+			matched++
+		}
+		total++
+		i++
+	}
+
+	if total == 0 {
+		return 0
+	}
+	return float64(matched) / float64(total)
+}
+
+// cyrillic reports whether the letters of sample are in the 0xc0-0xff
+// range of the single-byte Cyrillic charsets, which follow each other
+// in words unlike the accented letters of windows-1252.
+func cyrillic(sample []byte) (lower, upper int, ok bool) {
+	var joined int
+	for i, b := range sample {
+		if b < 0xc0 {
+			continue
+		}
+
+		if b >= 0xe0 {
+			lower++
+		} else {
+			upper++
+		}
+
+		if (i > 0 && sample[i-1] >= 0xc0) || (i+1 < len(sample) && sample[i+1] >= 0xc0) {
+			joined++
+		}
+	}
+
+	letters := lower + upper
+	return lower, upper, letters > 0 && float64(joined)/float64(letters) >= 0.5
+}
+
+func isKana(r rune) bool {
+	return unicode.In(r, unicode.Hiragana, unicode.Katakana) && r < 0xff00
+}
+func isHangul(r rune) bool {
+	return unicode.Is(unicode.Hangul, r)
+}
+func isHan(r rune) bool {
+	return unicode.Is(unicode.Han, r)
+}
+
+// isGB2312Pair reports whether a GBK character is in its GB2312 range.
+func isGB2312Pair(lead, trail byte) bool {
+	return lead >= 0xa1 && lead <= 0xf7 && trail >= 0xa1 && trail <= 0xfe
+}
+
+// isBig5CommonPair reports whether a Big5 character is a frequently used one.
+func isBig5CommonPair(lead, trail byte) bool {
+	return lead >= 0xa4 && lead <= 0xc6
+}
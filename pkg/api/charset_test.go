@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+func TestDecodeBody(t *testing.T) {
+	const (
+		japaneseText = "こんにちは、世界。今日はいい天気ですね。公園を散歩しましょう。"
+		koreanText   = "안녕하세요 세계. 오늘은 날씨가 좋네요. 공원을 산책합시다."
+		chineseText  = "你好，世界。今天天气很好，我们去公园散步吧。"
+		taiwanText   = "你好，世界。今天天氣很好，我們去公園散步吧。"
+		russianText  = "Привет, мир. Сегодня хорошая погода, пойдём гулять в парк."
+		frenchText   = "Un café crème à la française, très bien préparé."
+	)
+
+	tests := []struct {
+		name        string
+		text        string
+		enc         encoding.Encoding
+		head        string
+		contentType string
+		charset     string
+	}{
+		{"utf-8", japaneseText, encoding.Nop, "", "text/html", "utf-8"},
+		{"ascii", "hello world", encoding.Nop, "", "text/html", "utf-8"},
+		{"utf-8 bom", "\xef\xbb\xbf" + frenchText, encoding.Nop, "", "text/html", "utf-8"},
+		{"header charset", japaneseText, japanese.ShiftJIS, "", "text/html; charset=shift_jis", "shift_jis"},
+		{"meta charset", japaneseText, japanese.EUCJP, `<meta charset="euc-jp">`, "text/html", "euc-jp"},
+		{"sniffed shift_jis", japaneseText, japanese.ShiftJIS, "", "text/html", "shift_jis"},
+		{"sniffed euc-jp", japaneseText, japanese.EUCJP, "", "text/html", "euc-jp"},
+		{"sniffed euc-kr", koreanText, korean.EUCKR, "", "text/html", "euc-kr"},
+		{"sniffed gbk", chineseText, simplifiedchinese.GBK, "", "text/html", "gbk"},
+		{"sniffed big5", taiwanText, traditionalchinese.Big5, "", "text/html", "big5"},
+		{"sniffed windows-1251", russianText, charmap.Windows1251, "", "text/html", "windows-1251"},
+		{"sniffed koi8-r", russianText, charmap.KOI8R, "", "text/plain", "koi8-r"},
+		{"sniffed windows-1252", frenchText, charmap.Windows1252, "", "text/html", "windows-1252"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := tt.enc.NewEncoder().String(tt.text)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+
+			body := []byte("<html><head>" + tt.head + "</head><body><p>" + text + "</p></body></html>")
+
+			decoded, charset := DecodeBody(body, tt.contentType)
+			if charset != tt.charset {
+				t.Fatalf("charset = %q, want %q", charset, tt.charset)
+			}
+
+			want := bytes.TrimPrefix([]byte(tt.text), utf8BOM)
+			if !bytes.Contains(decoded, want) {
+				t.Fatalf("decoded = %q, want it to contain %q", decoded, want)
+			}
+		})
+	}
+}
+
+func TestDecodeBodyBinary(t *testing.T) {
+	body := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	decoded, charset := DecodeBody(body, "image/png")
+	if charset != "" || !bytes.Equal(decoded, body) {
+		t.Fatalf("DecodeBody = %q, %q, want the body as is without charset", decoded, charset)
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type (
+	// Login is a login step run before the first request to Host, and
+	// again when a response shows the session expired. when Page is set,
+	// the login form is loaded from it, and its fields (e.g. a CSRF token)
+	// are submitted along Form to the form action, or to URL if set.
+	// Body, when set, is sent to URL instead of Form.
+	Login struct {
+		Host        string
+		Page        string
+		URL         string
+		Method      string
+		Form        url.Values
+		Body        []byte
+		ContentType string
+		Header      http.Header
+
+		// Success verifies the login response, a 2xx status by default.
+		Success func(resp *Response) bool
+
+		// Expired reports whether a response shows the session expired,
+		// a 401 status by default.
+		Expired func(resp *Response) bool
+	}
+)
+
+func (l *Login) Succeeded(resp *Response) bool {
+	if l.Success != nil {
+		return l.Success(resp)
+	}
+	return resp.Status >= 200 && resp.Status < 300
+}
+func (l *Login) IsExpired(resp *Response) bool {
+	if l.Expired != nil {
+		return l.Expired(resp)
+	}
+	return resp.Status == http.StatusUnauthorized
+}
+
+// ParseForm returns the action and the fields of the login form of a
+// page, the first form with a password input, or else the first form.
+func ParseForm(body []byte) (action string, fields url.Values) {
+	fields = url.Values{}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return action, fields
+	}
+
+	form := doc.Find("form").FilterFunction(func(index int, item *goquery.Selection) bool {
+		return item.Find(`input[type="password"]`).Length() > 0
+	}).First()
+	if form.Length() == 0 {
+		form = doc.Find("form").First()
+	}
+
+	action, _ = form.Attr("action")
+
+	form.Find("input[name]").Each(func(index int, item *goquery.Selection) {
+		name, _ := item.Attr("name")
+		value, _ := item.Attr("value")
+
+		switch strings.ToLower(item.AttrOr("type", "text")) {
+		case "submit", "button", "image", "reset", "file":
+			return
+		case "checkbox", "radio":
+			if _, checked := item.Attr("checked"); !checked {
+				return
+			}
+		}
+
+		fields.Add(name, value)
+	})
+
+	return action, fields
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type (
+	// RobotsDirectives are the page level robots directives,
+	// from the X-Robots-Tag header and the robots meta tags.
+	RobotsDirectives struct {
+		NoIndex   bool
+		NoFollow  bool
+		NoArchive bool
+	}
+)
+
+var (
+	// directives whose value follows a colon, which must not be
+	// mistaken for a user agent in X-Robots-Tag.
+	valuedDirectives = map[string]bool{
+		"unavailable_after": true,
+		"max-snippet":       true,
+		"max-image-preview": true,
+		"max-video-preview": true,
+	}
+)
+
+// ParseRobotsDirectives collects the directives that apply to userAgent,
+// generic ones and the ones targeting its product token.
+func ParseRobotsDirectives(header http.Header, body []byte, userAgent string) RobotsDirectives {
+	var (
+		d     RobotsDirectives
+		token = strings.ToLower(ProductToken(userAgent))
+	)
+
+	for _, value := range header.Values("X-Robots-Tag") {
+		agent, directives := splitRobotsTag(value)
+		if agent == "" || agent == token {
+			d.apply(directives)
+		}
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return d
+	}
+
+	doc.Find("meta[name][content]").Each(func(index int, item *goquery.Selection) {
+		name := strings.ToLower(strings.TrimSpace(item.AttrOr("name", "")))
+		if name == "robots" || name == token {
+			d.apply(item.AttrOr("content", ""))
+		}
+	})
+
+	return d
+}
+
+// ProductToken returns the product token of a user agent,
+// e.g. "WBot" for "WBot/v0.2.0 (+https://github.com/twiny/wbot)".
+func ProductToken(userAgent string) string {
+	token := strings.TrimSpace(userAgent)
+	if i := strings.IndexAny(token, "/ "); i >= 0 {
+		token = token[:i]
+	}
+
+	return token
+}
+
+func (d *RobotsDirectives) apply(directives string) {
+	for _, directive := range strings.Split(directives, ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "noindex":
+			d.NoIndex = true
+		case "nofollow":
+			d.NoFollow = true
+		case "noarchive":
+			d.NoArchive = true
+		case "none":
+			d.NoIndex = true
+			d.NoFollow = true
+		}
+	}
+}
+
+// splitRobotsTag splits an X-Robots-Tag value into
+// its optional user agent and its directives.
+func splitRobotsTag(value string) (agent, directives string) {
+	i := strings.Index(value, ":")
+	if i < 0 {
+		return "", value
+	}
+
+	name := strings.ToLower(strings.TrimSpace(value[:i]))
+	if valuedDirectives[name] || strings.Contains(name, ",") {
+		return "", value
+	}
+
+	return name, value[i+1:]
+}
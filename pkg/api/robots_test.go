@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseRobotsDirectives(t *testing.T) {
+	const userAgent = "WBot/v0.2.0 (+https://github.com/twiny/wbot)"
+
+	tests := []struct {
+		name   string
+		header []string
+		meta   string
+		want   RobotsDirectives
+	}{
+		{"none", nil, "", RobotsDirectives{}},
+		{"header", []string{"noindex, nofollow"}, "", RobotsDirectives{NoIndex: true, NoFollow: true}},
+		{"header none", []string{"none"}, "", RobotsDirectives{NoIndex: true, NoFollow: true}},
+		{"header case", []string{"NoArchive"}, "", RobotsDirectives{NoArchive: true}},
+		{"header for agent", []string{"wbot: nofollow"}, "", RobotsDirectives{NoFollow: true}},
+		{"header for other agent", []string{"googlebot: noindex"}, "", RobotsDirectives{}},
+		{"header values", []string{"googlebot: noindex", "noarchive"}, "", RobotsDirectives{NoArchive: true}},
+		{"header valued directive", []string{"unavailable_after: 25 Jun 2010 15:00:00 PST"}, "", RobotsDirectives{}},
+		{"meta", nil, `<meta name="robots" content="noindex">`, RobotsDirectives{NoIndex: true}},
+		{"meta for agent", nil, `<meta name="WBot" content="nofollow">`, RobotsDirectives{NoFollow: true}},
+		{"meta for other agent", nil, `<meta name="googlebot" content="noindex">`, RobotsDirectives{}},
+		{"header and meta", []string{"noarchive"}, `<meta name="robots" content="nofollow">`, RobotsDirectives{NoFollow: true, NoArchive: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for _, value := range tt.header {
+				header.Add("X-Robots-Tag", value)
+			}
+			body := []byte("<html><head>" + tt.meta + "</head><body></body></html>")
+
+			if got := ParseRobotsDirectives(header, body, userAgent); got != tt.want {
+				t.Fatalf("ParseRobotsDirectives = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitRobotsTag(t *testing.T) {
+	tests := []struct {
+		value      string
+		agent      string
+		directives string
+	}{
+		{"noindex", "", "noindex"},
+		{"noindex, nofollow", "", "noindex, nofollow"},
+		{"googlebot: noindex", "googlebot", " noindex"},
+		{"GoogleBot : noindex", "googlebot", " noindex"},
+		{"unavailable_after: 25 Jun 2010", "", "unavailable_after: 25 Jun 2010"},
+		{"max-snippet: 20, noarchive", "", "max-snippet: 20, noarchive"},
+		{"noindex, max-snippet: 20", "", "noindex, max-snippet: 20"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			agent, directives := splitRobotsTag(tt.value)
+			if agent != tt.agent || directives != tt.directives {
+				t.Fatalf("splitRobotsTag(%q) = %q, %q, want %q, %q", tt.value, agent, directives, tt.agent, tt.directives)
+			}
+		})
+	}
+}
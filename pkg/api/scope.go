@@ -0,0 +1,84 @@
+package api
+
+import (
+	"strings"
+)
+
+type (
+	// Scope reports whether target belongs to the crawl started from seed.
+	Scope interface {
+		Contains(seed, target *ParsedURL) bool
+	}
+
+	// ScopeFunc is a custom Scope predicate.
+	ScopeFunc func(seed, target *ParsedURL) bool
+)
+
+func (fn ScopeFunc) Contains(seed, target *ParsedURL) bool {
+	return fn(seed, target)
+}
+
+// HostScope keeps the crawl on the exact host of the seed.
+func HostScope() Scope {
+	return ScopeFunc(func(seed, target *ParsedURL) bool {
+		return strings.EqualFold(target.URL.Hostname(), seed.URL.Hostname())
+	})
+}
+
+// DomainScope keeps the crawl on the registrable domain of the seed
+// and all of its subdomains.
+func DomainScope() Scope {
+	return ScopeFunc(func(seed, target *ParsedURL) bool {
+		return target.Root == seed.Root
+	})
+}
+
+// PathScope keeps the crawl on the host of the seed, under the path
+// prefix, e.g. "/docs" matches "/docs" and "/docs/intro" but not
+// "/docs-old". an empty prefix uses the directory of the seed.
+func PathScope(prefix string) Scope {
+	return ScopeFunc(func(seed, target *ParsedURL) bool {
+		if !strings.EqualFold(target.URL.Hostname(), seed.URL.Hostname()) {
+			return false
+		}
+
+		p := prefix
+		if p == "" {
+			p = seed.URL.Path[:strings.LastIndex(seed.URL.Path, "/")+1]
+		}
+		p = strings.TrimSuffix(p, "/")
+
+		return target.URL.Path == p || strings.HasPrefix(target.URL.Path, p+"/")
+	})
+}
+
+// HostsScope keeps the crawl on the listed hosts, regardless of the seed.
+// a host starting with "*." also matches all of its subdomains.
+func HostsScope(hosts ...string) Scope {
+	exact := make(map[string]bool)
+	var wildcards []string
+
+	for _, host := range hosts {
+		host = strings.ToLower(host)
+		if strings.HasPrefix(host, "*.") {
+			wildcards = append(wildcards, host[1:])
+			continue
+		}
+		exact[host] = true
+	}
+
+	return ScopeFunc(func(seed, target *ParsedURL) bool {
+		host := strings.ToLower(target.URL.Hostname())
+		if exact[host] {
+			return true
+		}
+
+		for _, suffix := range wildcards {
+			if strings.HasSuffix(host, suffix) || host == suffix[1:] {
+				return true
+			}
+		}
+
+		return false
+	})
+}
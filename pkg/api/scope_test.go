@@ -0,0 +1,75 @@
+package api
+
+import "testing"
+
+func TestPathScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		seed   string
+		target string
+		want   bool
+	}{
+		{"prefix itself", "/docs", "https://example.com/docs/", "https://example.com/docs", true},
+		{"under prefix", "/docs", "https://example.com/", "https://example.com/docs/intro", true},
+		{"trailing slash prefix", "/docs/", "https://example.com/", "https://example.com/docs/intro", true},
+		{"sibling path", "/docs", "https://example.com/", "https://example.com/docs-old", false},
+		{"outside prefix", "/docs", "https://example.com/", "https://example.com/blog", false},
+		{"other host", "/docs", "https://example.com/", "https://www.example.com/docs/intro", false},
+		{"host case", "/docs", "https://example.com/", "https://EXAMPLE.com/docs", true},
+		{"seed directory", "", "https://example.com/docs/intro", "https://example.com/docs/setup", true},
+		{"outside seed directory", "", "https://example.com/docs/intro", "https://example.com/blog", false},
+		{"seed at root", "", "https://example.com/", "https://example.com/blog", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seed, target := newTestURL(t, tt.seed), newTestURL(t, tt.target)
+
+			if got := PathScope(tt.prefix).Contains(seed, target); got != tt.want {
+				t.Fatalf("PathScope(%q).Contains(%s, %s) = %v, want %v", tt.prefix, tt.seed, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostsScope(t *testing.T) {
+	hosts := []string{"example.com", "*.example.org"}
+
+	tests := []struct {
+		name   string
+		target string
+		want   bool
+	}{
+		{"exact host", "https://example.com/", true},
+		{"exact host case", "https://Example.COM/", true},
+		{"subdomain of exact host", "https://www.example.com/", false},
+		{"wildcard subdomain", "https://www.example.org/", true},
+		{"wildcard nested subdomain", "https://a.b.example.org/", true},
+		{"wildcard apex", "https://example.org/", true},
+		{"wildcard suffix only", "https://badexample.org/", false},
+		{"unlisted host", "https://example.net/", false},
+	}
+
+	// the seed does not matter.
+	seed := newTestURL(t, "https://example.net/")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HostsScope(hosts...).Contains(seed, newTestURL(t, tt.target)); got != tt.want {
+				t.Fatalf("HostsScope(%v).Contains(%s) = %v, want %v", hosts, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestURL(t *testing.T, raw string) *ParsedURL {
+	t.Helper()
+
+	u, err := NewURL(raw)
+	if err != nil {
+		t.Fatalf("new url %s: %v", raw, err)
+	}
+
+	return u
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// the sitemap protocol limits a sitemap to 50MB uncompressed.
+	MaxSitemapSize = int64(50 * 1024 * 1024)
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+type (
+	// Sitemap is a parsed urlset, sitemap index or text sitemap.
+	Sitemap struct {
+		URLs     []*SitemapURL
+		Sitemaps []string
+	}
+
+	SitemapURL struct {
+		Loc        string `xml:"loc"`
+		LastMod    string `xml:"lastmod"`
+		ChangeFreq string `xml:"changefreq"`
+		Priority   string `xml:"priority"`
+	}
+
+	sitemapDocument struct {
+		XMLName  xml.Name
+		URLs     []*SitemapURL `xml:"url"`
+		Sitemaps []struct {
+			Loc string `xml:"loc"`
+		} `xml:"sitemap"`
+	}
+)
+
+// ParseSitemap parses an XML urlset or sitemap index, or a text sitemap
+// with one URL per line. gzip compressed sitemaps are decompressed.
+func ParseSitemap(body []byte) (*Sitemap, error) {
+	if bytes.HasPrefix(body, gzipMagic) {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip sitemap: %w", err)
+		}
+		defer zr.Close()
+
+		body, err = io.ReadAll(io.LimitReader(zr, MaxSitemapSize))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip sitemap: %w", err)
+		}
+	}
+
+	body = bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))
+	if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("<")) {
+		return parseTextSitemap(body)
+	}
+
+	var doc sitemapDocument
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("invalid xml sitemap: %w", err)
+	}
+
+	sitemap := &Sitemap{}
+	switch doc.XMLName.Local {
+	case "urlset":
+		for _, u := range doc.URLs {
+			u.Loc = strings.TrimSpace(u.Loc)
+			if u.Loc != "" {
+				sitemap.URLs = append(sitemap.URLs, u)
+			}
+		}
+	case "sitemapindex":
+		for _, s := range doc.Sitemaps {
+			if loc := strings.TrimSpace(s.Loc); loc != "" {
+				sitemap.Sitemaps = append(sitemap.Sitemaps, loc)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid xml sitemap: unexpected <%s>", doc.XMLName.Local)
+	}
+
+	return sitemap, nil
+}
+
+func parseTextSitemap(body []byte) (*Sitemap, error) {
+	sitemap := &Sitemap{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://") {
+			sitemap.URLs = append(sitemap.URLs, &SitemapURL{Loc: line})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("invalid text sitemap: %w", err)
+	}
+
+	return sitemap, nil
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"testing"
+)
+
+func TestParseSitemap(t *testing.T) {
+	const (
+		urlset = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc> https://example.com/a </loc><lastmod>2024-01-02</lastmod><priority>0.8</priority></url>
+	<url><loc></loc></url>
+	<url><loc>https://example.com/b</loc></url>
+</urlset>`
+		index = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>https://example.com/sitemap-1.xml</loc></sitemap>
+	<sitemap><loc>https://example.com/sitemap-2.xml.gz</loc></sitemap>
+</sitemapindex>`
+		text = "https://example.com/a\n\n  https://example.com/b  \r\nnot a url\nftp://example.com/c\n"
+	)
+
+	tests := []struct {
+		name     string
+		body     []byte
+		urls     []string
+		sitemaps []string
+		err      bool
+	}{
+		{"urlset", []byte(urlset), []string{"https://example.com/a", "https://example.com/b"}, nil, false},
+		{"urlset with bom", append([]byte("\xef\xbb\xbf"), urlset...), []string{"https://example.com/a", "https://example.com/b"}, nil, false},
+		{"gzip urlset", gzipTestBody(t, urlset), []string{"https://example.com/a", "https://example.com/b"}, nil, false},
+		{"index", []byte(index), nil, []string{"https://example.com/sitemap-1.xml", "https://example.com/sitemap-2.xml.gz"}, false},
+		{"gzip index", gzipTestBody(t, index), nil, []string{"https://example.com/sitemap-1.xml", "https://example.com/sitemap-2.xml.gz"}, false},
+		{"text", []byte(text), []string{"https://example.com/a", "https://example.com/b"}, nil, false},
+		{"gzip text", gzipTestBody(t, text), []string{"https://example.com/a", "https://example.com/b"}, nil, false},
+		{"empty", nil, nil, nil, false},
+		{"unexpected root", []byte("<rss></rss>"), nil, nil, true},
+		{"invalid xml", []byte("<urlset><url>"), nil, nil, true},
+		{"invalid gzip", []byte{0x1f, 0x8b, 0x00}, nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sitemap, err := ParseSitemap(tt.body)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("ParseSitemap = %+v, want error", sitemap)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSitemap: %v", err)
+			}
+
+			var urls []string
+			for _, u := range sitemap.URLs {
+				urls = append(urls, u.Loc)
+			}
+
+			if !reflect.DeepEqual(urls, tt.urls) {
+				t.Fatalf("urls = %v, want %v", urls, tt.urls)
+			}
+			if !reflect.DeepEqual(sitemap.Sitemaps, tt.sitemaps) {
+				t.Fatalf("sitemaps = %v, want %v", sitemap.Sitemaps, tt.sitemaps)
+			}
+		})
+	}
+}
+
+func TestParseSitemapMeta(t *testing.T) {
+	body := []byte(`<urlset><url><loc>https://example.com/a</loc><lastmod>2024-01-02</lastmod><changefreq>daily</changefreq><priority>0.8</priority></url></urlset>`)
+
+	sitemap, err := ParseSitemap(body)
+	if err != nil {
+		t.Fatalf("ParseSitemap: %v", err)
+	}
+
+	want := []*SitemapURL{{Loc: "https://example.com/a", LastMod: "2024-01-02", ChangeFreq: "daily", Priority: "0.8"}}
+	if !reflect.DeepEqual(sitemap.URLs, want) {
+		t.Fatalf("urls = %+v, want %+v", sitemap.URLs, want)
+	}
+}
+
+func gzipTestBody(t *testing.T, body string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
@@ -7,7 +7,10 @@ import (
 	_ "embed"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
@@ -23,6 +26,10 @@ var (
 	tldsBytes []byte
 	tlds      = map[string]bool{}
 	once      = &sync.Once{}
+
+	ErrQueueEmpty   = errors.New("queue is empty")
+	ErrQueueClosed  = errors.New("queue is closed")
+	ErrQueueCorrupt = errors.New("queue record is corrupt")
 )
 
 func init() {
@@ -45,13 +52,49 @@ type (
 		Close() error
 	}
 
+	// VisitChecker is implemented by stores that can report whether
+	// u is visited without marking it, unlike HasVisited.
+	VisitChecker interface {
+		Visited(ctx context.Context, u *ParsedURL) (bool, error)
+	}
+
+	// HTTPCache stores the validators, and optionally the body, of fetched
+	// pages for conditional requests. Get returns nil when key is unknown.
+	HTTPCache interface {
+		Get(ctx context.Context, key string) (*CacheEntry, error)
+		Put(ctx context.Context, key string, entry *CacheEntry) error
+		Close() error
+	}
+
+	// Queue is the crawl frontier.
+	// Pop returns ErrQueueEmpty right away when no request is available,
+	// PopWait blocks until a request is available or ctx is done.
+	// both return ErrQueueClosed once the queue is closed, and
+	// ErrQueueCorrupt for each request dropped as unreadable.
 	Queue interface {
 		Push(ctx context.Context, req *Request) error
 		Pop(ctx context.Context) (*Request, error)
+		PopWait(ctx context.Context) (*Request, error)
 		Len() int32
 		Close() error
 	}
 
+	// Checkpointer is implemented by services whose state
+	// can be saved and restored to resume a crawl later.
+	Checkpointer interface {
+		Checkpoint(w io.Writer) error
+		Restore(r io.Reader) error
+	}
+
+	// ProxyPool picks the proxy of each request, and tracks the
+	// health of its proxies from the reported results.
+	// Next returns an empty string when the pool has no proxy.
+	ProxyPool interface {
+		Next(host string) string
+		Report(proxy string, elapsed time.Duration, err error)
+		Stats() []*ProxyStats
+	}
+
 	MetricsMonitor interface {
 		IncTotalRequests()
 		IncSuccessfulRequests()
@@ -66,19 +109,65 @@ type (
 	}
 
 	Request struct {
-		Target *ParsedURL
-		Param  *Param
-		Depth  int32
+		Target   *ParsedURL
+		Seed     *ParsedURL
+		Param    *Param
+		Depth    int32
+		Priority float64
+
+		// ExternalHops counts the consecutive links followed outside the seed scope.
+		ExternalHops int32
+
+		// Meta carries information about how the request was discovered,
+		// e.g. the sitemap lastmod and priority.
+		Meta map[string]string
+
+		// Attempt counts the retries of the request.
+		Attempt int32
+
+		// Method defaults to GET, Body is sent with ContentType.
+		Method      string
+		Body        []byte
+		ContentType string
 	}
 
+	// Response is a fetched page, FinalURL is the URL it was served
+	// from after redirects and ContentType its media type without
+	// parameters, e.g. "text/html". Charset is the detected charset
+	// of a text body, e.g. "shift_jis".
 	Response struct {
 		URL         *ParsedURL
+		FinalURL    *ParsedURL
 		Status      int
+		Proto       string
+		Header      http.Header
+		ContentType string
+		Charset     string
 		Body        []byte
+
+		// UTF8Body is Body decoded from Charset to UTF-8,
+		// it shares Body when no decoding is needed.
+		UTF8Body []byte
+
+		// BodySize is the number of body bytes received. a streamed body
+		// is not kept in Body, BodyFile is then the temp file it was
+		// written to, if any, and is owned by the caller.
+		// Truncated is set when the body hit its size limit.
+		BodySize  int64
+		BodyFile  string
+		Truncated bool
+
+		Redirects   []*Redirect
 		NextURLs    []*ParsedURL
+		Canonical   *ParsedURL
+		Robots      RobotsDirectives
 		Depth       int32
 		ElapsedTime time.Duration
 		Err         error
+
+		// NotModified is set when the server answered a conditional
+		// request with 304, Body then holds the cached body, if any.
+		NotModified bool
 	}
 
 	ParsedURL struct {
@@ -93,6 +182,65 @@ type (
 		Referer     string
 		MaxBodySize int64
 		Timeout     time.Duration
+
+		// FollowRobots makes the fetcher honor nofollow directives
+		// when collecting the next URLs of a page.
+		FollowRobots bool
+
+		// SkipNofollowLinks drops the links marked with
+		// rel nofollow, ugc or sponsored from the next URLs.
+		SkipNofollowLinks bool
+
+		// Header is added to the request, after the header rules
+		// of the fetcher. it is only sent to the host of the request,
+		// and not passed on to the links out of its scope.
+		Header http.Header
+
+		// Internal marks the crawler's own fetches, e.g. robots.txt and
+		// sitemaps, their body is not passed to a body writer.
+		Internal bool `json:"-"`
+
+		// CheckRedirect is called before following a redirect to target,
+		// hop being its position in the chain from 1. returning
+		// http.ErrUseLastResponse stops at the redirect response.
+		CheckRedirect func(target *url.URL, hop int) error `json:"-"`
+	}
+
+	Link struct {
+		Href string
+		Rel  []string
+	}
+
+	// ProxyStats is the health of a proxy, Latency is the average
+	// latency of its successful requests.
+	ProxyStats struct {
+		Proxy        string
+		Successes    int64
+		Failures     int64
+		Latency      time.Duration
+		BenchedUntil time.Time
+	}
+
+	CacheEntry struct {
+		ETag         string
+		LastModified string
+		Header       http.Header
+		Body         []byte
+		StoredAt     time.Time
+	}
+
+	// HeaderRule adds Header to the requests sent to Hostname,
+	// "*" matches every host without a rule of its own.
+	HeaderRule struct {
+		Hostname string
+		Header   http.Header
+	}
+
+	// AuthRule authenticates the requests sent to Hostname,
+	// "*" matches every host without a rule of its own.
+	AuthRule struct {
+		Hostname string
+		Auth     Authenticator
 	}
 
 	FilterRule struct {
@@ -101,12 +249,90 @@ type (
 		Disallow []*regexp.Regexp
 	}
 
+	// RateLimit paces the requests to the registrable domain Hostname
+	// and its subdomains together, e.g. "10/1s". the "*" rule paces
+	// each host without a rule of its own separately.
 	RateLimit struct {
 		Hostname string
 		Rate     string
 	}
+
+	// RetryPolicy controls how failed requests are retried.
+	// the delay before a retry doubles with each attempt, from BaseDelay
+	// up to MaxDelay, and is randomized by +/- Jitter (0 to 1).
+	// a Retry-After header on 429 and 503 responses is honored, the
+	// request is not retried when it is longer than MaxDelay.
+	RetryPolicy struct {
+		MaxAttempts int
+		BaseDelay   time.Duration
+		MaxDelay    time.Duration
+		Jitter      float64
+
+		// StatusCodes are the retryable response statuses.
+		StatusCodes []int
+
+		// Retryable reports whether a fetch error is retryable,
+		// all errors are retried when nil.
+		Retryable func(err error) bool
+	}
+
+	// RedirectPolicy controls how redirects are followed. each hop must
+	// pass the scope, filter, robots.txt and visited checks of a link,
+	// a redirect that is not followed is returned as is, and its target
+	// is queued as a link.
+	RedirectPolicy struct {
+		// MaxRedirects is the longest followed chain, 10 by default.
+		MaxRedirects int
+
+		// SameScope stops at redirects leaving the scope of the seed,
+		// even within the allowed external hops.
+		SameScope bool
+
+		// NoFollow never follows redirects.
+		NoFollow bool
+	}
+
+	// Redirect is a hop of a redirect chain.
+	Redirect struct {
+		URL      string
+		Location string
+		Status   int
+	}
 )
 
+// NewFormRequest returns a POST request submitting form to target.
+func NewFormRequest(target *ParsedURL, form url.Values) *Request {
+	return &Request{
+		Target:      target,
+		Method:      http.MethodPost,
+		Body:        []byte(form.Encode()),
+		ContentType: "application/x-www-form-urlencoded",
+	}
+}
+
+// Identity returns the URL a request is deduplicated by, a GET request
+// is identified by its target, other methods also by their body.
+func (r *Request) Identity() *ParsedURL {
+	method := r.HTTPMethod()
+	if method == http.MethodGet {
+		return r.Target
+	}
+
+	hasher := sha256.New()
+	hasher.Write([]byte(method + " " + r.Target.Hash + " " + r.ContentType + " "))
+	hasher.Write(r.Body)
+
+	identity := *r.Target
+	identity.Hash = hex.EncodeToString(hasher.Sum(nil))
+
+	return &identity
+}
+func (r *Request) HTTPMethod() string {
+	if r.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(r.Method)
+}
 func (r *Request) ResolveURL(u string) (*url.URL, error) {
 	if strings.HasPrefix(u, "#") {
 		return nil, fmt.Errorf("url is a fragment")
@@ -121,6 +347,23 @@ func (r *Request) ResolveURL(u string) (*url.URL, error) {
 
 	return absURL, nil
 }
+func (u *ParsedURL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(u.URL.String())
+}
+func (u *ParsedURL) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	parsed, err := NewURL(raw)
+	if err != nil {
+		return err
+	}
+
+	*u = *parsed
+	return nil
+}
 func (u *ParsedURL) String() string {
 	var link = u.URL.String()
 	if len(link) > 64 {
@@ -163,37 +406,61 @@ func NewURL(raw string) (*ParsedURL, error) {
 	}, nil
 }
 func FindLinks(body []byte) (hrefs []string) {
+	links, _ := ParseLinks(body)
+	for _, link := range links {
+		hrefs = append(hrefs, link.Href)
+	}
+	return hrefs
+}
+
+// ParseLinks returns the links of a page with their rel values,
+// and the href of the canonical link of the page, if any.
+func ParseLinks(body []byte) (links []*Link, canonical string) {
 	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
 	if err != nil {
-		return hrefs
+		return links, canonical
 	}
 
 	doc.Find("a[href]").Each(func(index int, item *goquery.Selection) {
 		if href, found := item.Attr("href"); found {
-			hrefs = append(hrefs, href)
+			links = append(links, &Link{Href: href, Rel: parseRel(item)})
 		}
 	})
 	doc.Find("link[href]").Each(func(index int, item *goquery.Selection) {
 		if href, found := item.Attr("href"); found {
-			hrefs = append(hrefs, href)
+			link := &Link{Href: href, Rel: parseRel(item)}
+			if canonical == "" && link.HasRel("canonical") {
+				canonical = href
+			}
+			links = append(links, link)
 		}
 	})
 	doc.Find("img[src]").Each(func(index int, item *goquery.Selection) {
 		if src, found := item.Attr("src"); found {
-			hrefs = append(hrefs, src)
+			links = append(links, &Link{Href: src})
 		}
 	})
 	doc.Find("script[src]").Each(func(index int, item *goquery.Selection) {
 		if src, found := item.Attr("src"); found {
-			hrefs = append(hrefs, src)
+			links = append(links, &Link{Href: src})
 		}
 	})
 	doc.Find("iframe[src]").Each(func(index int, item *goquery.Selection) {
 		if src, found := item.Attr("src"); found {
-			hrefs = append(hrefs, src)
+			links = append(links, &Link{Href: src})
 		}
 	})
-	return hrefs
+	return links, canonical
+}
+func (l *Link) HasRel(values ...string) bool {
+	for _, rel := range l.Rel {
+		for _, value := range values {
+			if rel == value {
+				return true
+			}
+		}
+	}
+	return false
 }
 func Hostname(link string) (string, error) {
 	u, err := url.Parse(link)
@@ -216,6 +483,13 @@ func Hostname(link string) (string, error) {
 	return domain, nil
 }
 
+func parseRel(item *goquery.Selection) []string {
+	rel, found := item.Attr("rel")
+	if !found {
+		return nil
+	}
+	return strings.Fields(strings.ToLower(rel))
+}
 func hashLink(parsedLink url.URL) (string, error) {
 	parsedLink.Scheme = ""
 
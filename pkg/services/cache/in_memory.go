@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"context"
+	"sync"
+
+	"github.com/twiny/wbot/pkg/api"
+)
+
+type (
+	defaultInMemoryCache struct {
+		mu    sync.RWMutex
+		table map[string]*api.CacheEntry
+	}
+)
+
+func NewInMemoryCache() api.HTTPCache {
+	return &defaultInMemoryCache{
+		table: make(map[string]*api.CacheEntry),
+	}
+}
+func (c *defaultInMemoryCache) Get(ctx context.Context, key string) (*api.CacheEntry, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.table[key], nil
+}
+func (c *defaultInMemoryCache) Put(ctx context.Context, key string, entry *api.CacheEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.table[key] = entry
+	return nil
+}
+func (c *defaultInMemoryCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.table)
+	return nil
+}
@@ -0,0 +1,194 @@
+package fetcher
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+
+	"github.com/twiny/wbot/pkg/api"
+)
+
+type (
+	// body is a response body, read in memory or streamed to a writer.
+	body struct {
+		data      []byte
+		file      string
+		size      int64
+		streamed  bool
+		truncated bool
+
+		// prefix is the start of a streamed text body, to parse it.
+		prefix []byte
+	}
+
+	// prefixWriter keeps the first limit bytes written to it.
+	prefixWriter struct {
+		buf   bytes.Buffer
+		limit int64
+	}
+)
+
+// WithDownloads streams binary bodies, and bodies larger than threshold,
+// to temp files in dir instead of memory. the links, charset and robots
+// directives of a streamed text body are parsed from its first
+// Param.MaxBodySize bytes. streamed bodies are limited to maxSize bytes,
+// 0 for no limit. Param.Timeout still bounds the fetch, see
+// wbot.WithTimeout for large downloads.
+func WithDownloads(dir string, threshold, maxSize int64) Option {
+	return func(f *defaultHTTPClient) {
+		f.downloads = true
+		f.downloadDir = dir
+		f.downloadThreshold = threshold
+		f.maxDownloadSize = maxSize
+	}
+}
+
+// WithBodyWriter streams the bodies for which fn returns a writer, it is
+// closed once the body is written if it is an io.Closer. a nil writer
+// keeps the default handling. the crawler's own fetches, e.g. robots.txt
+// and sitemaps, are not passed to fn.
+func WithBodyWriter(fn func(req *api.Request, header http.Header) (io.Writer, error)) Option {
+	return func(f *defaultHTTPClient) {
+		f.bodyWriter = fn
+	}
+}
+
+// readBody reads small bodies into memory, copied out of the pooled
+// buffer so a response owns its body, and streams the others.
+func (f *defaultHTTPClient) readBody(req *api.Request, resp *http.Response) (*body, error) {
+	w, file, err := f.sink(req, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if w != nil {
+		return f.stream(req, w, file, resp)
+	}
+
+	buf := f.bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer f.bufferPool.Put(buf)
+
+	n, truncated, err := copyLimit(buf, resp.Body, req.Param.MaxBodySize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &body{
+		data:      bytes.Clone(buf.Bytes()),
+		size:      n,
+		truncated: truncated,
+	}, nil
+}
+
+// sink returns the writer a body is streamed to, and its temp file if
+// any, or nil when the body is read into memory.
+func (f *defaultHTTPClient) sink(req *api.Request, resp *http.Response) (io.Writer, *os.File, error) {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, nil
+	}
+
+	if f.bodyWriter != nil && !req.Param.Internal {
+		w, err := f.bodyWriter(req, resp.Header)
+		if err != nil || w != nil {
+			return w, nil, err
+		}
+	}
+
+	if !f.downloads || !f.download(resp) {
+		return nil, nil, nil
+	}
+
+	file, err := os.CreateTemp(f.downloadDir, "wbot-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("create download: %w", err)
+	}
+
+	return file, file, nil
+}
+
+// download reports whether a body is streamed, binary bodies are and
+// so are the bodies larger than the threshold.
+func (f *defaultHTTPClient) download(resp *http.Response) bool {
+	if f.downloadThreshold > 0 && resp.ContentLength > f.downloadThreshold {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && !api.IsTextType(mediaType)
+}
+func (f *defaultHTTPClient) stream(req *api.Request, w io.Writer, file *os.File, resp *http.Response) (*body, error) {
+	// a text body is parsed from its first bytes.
+	var (
+		dst    = w
+		prefix *prefixWriter
+	)
+	if mediaType := contentType(resp.Header); mediaType == "" || api.IsTextType(mediaType) {
+		prefix = &prefixWriter{limit: req.Param.MaxBodySize}
+		dst = io.MultiWriter(w, prefix)
+	}
+
+	n, truncated, err := copyLimit(dst, resp.Body, f.maxDownloadSize)
+
+	var closeErr error
+	if closer, ok := w.(io.Closer); ok {
+		closeErr = closer.Close()
+	}
+
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		if file != nil {
+			os.Remove(file.Name())
+		}
+		return nil, fmt.Errorf("stream body: %w", err)
+	}
+
+	b := &body{
+		size:      n,
+		streamed:  true,
+		truncated: truncated,
+	}
+	if file != nil {
+		b.file = file.Name()
+	}
+	if prefix != nil {
+		b.prefix = prefix.buf.Bytes()
+	}
+
+	return b, nil
+}
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	if room := p.limit - int64(p.buf.Len()); room > 0 {
+		p.buf.Write(b[:min(int64(len(b)), room)])
+	}
+	return len(b), nil
+}
+
+// copyLimit copies at most limit bytes, 0 for no limit, and reports
+// whether src had more.
+func copyLimit(dst io.Writer, src io.Reader, limit int64) (int64, bool, error) {
+	if limit <= 0 {
+		n, err := io.Copy(dst, src)
+		return n, false, err
+	}
+
+	n, err := io.CopyN(dst, src, limit)
+	if err != nil && err != io.EOF {
+		return n, false, err
+	}
+
+	if n < limit {
+		return n, false, nil
+	}
+
+	var probe [1]byte
+	more, _ := io.ReadFull(src, probe[:])
+
+	return n, more > 0, nil
+}
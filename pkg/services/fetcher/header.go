@@ -0,0 +1,103 @@
+package fetcher
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/twiny/wbot/pkg/api"
+)
+
+// WithHeaders adds the header of the matching rule to each request.
+func WithHeaders(rules ...*api.HeaderRule) Option {
+	return func(f *defaultHTTPClient) {
+		if f.headers == nil {
+			f.headers = make(map[string]*api.HeaderRule)
+		}
+		for _, rule := range rules {
+			f.headers[strings.ToLower(rule.Hostname)] = rule
+		}
+	}
+}
+
+// WithAuth authenticates each request with the matching rule.
+func WithAuth(rules ...*api.AuthRule) Option {
+	return func(f *defaultHTTPClient) {
+		if f.auths == nil {
+			f.auths = make(map[string]*api.AuthRule)
+		}
+		for _, rule := range rules {
+			f.auths[strings.ToLower(rule.Hostname)] = rule
+		}
+	}
+}
+
+// authorize sets the custom headers and the credentials of req from the
+// rules of its host, and returns the previous values of the headers it
+// changed, nil for the headers it added.
+func (f *defaultHTTPClient) authorize(httpReq *http.Request, req *api.Request) (http.Header, error) {
+	host := httpReq.URL.Hostname()
+	before := httpReq.Header.Clone()
+
+	if rule := f.headerRule(host); rule != nil {
+		for key, values := range rule.Header {
+			httpReq.Header[http.CanonicalHeaderKey(key)] = values
+		}
+	}
+
+	// the header of the request is only sent to its own host.
+	if strings.EqualFold(host, req.Target.URL.Hostname()) {
+		for key, values := range req.Param.Header {
+			httpReq.Header[http.CanonicalHeaderKey(key)] = values
+		}
+	}
+
+	if rule := f.authRule(host); rule != nil && rule.Auth != nil {
+		if err := rule.Auth.Authenticate(httpReq); err != nil {
+			return nil, fmt.Errorf("authenticate: %w", err)
+		}
+	}
+
+	changed := make(http.Header)
+	for key, values := range httpReq.Header {
+		if !slices.Equal(values, before[key]) {
+			changed[key] = before[key]
+		}
+	}
+
+	return changed, nil
+}
+
+// reauthorize applies the rules of the host of a redirect. each hop
+// starts from the header of the first request, the headers set for its
+// host are undone first so they are not forwarded to another host.
+func (f *defaultHTTPClient) reauthorize(httpReq *http.Request, changed http.Header, req *api.Request) error {
+	for key, values := range changed {
+		// headers dropped by the client on this hop stay dropped.
+		if _, found := httpReq.Header[key]; !found {
+			continue
+		}
+
+		if values == nil {
+			httpReq.Header.Del(key)
+			continue
+		}
+		httpReq.Header[key] = values
+	}
+
+	_, err := f.authorize(httpReq, req)
+	return err
+}
+func (f *defaultHTTPClient) headerRule(host string) *api.HeaderRule {
+	if rule, found := f.headers[strings.ToLower(host)]; found {
+		return rule
+	}
+	return f.headers["*"]
+}
+func (f *defaultHTTPClient) authRule(host string) *api.AuthRule {
+	if rule, found := f.auths[strings.ToLower(host)]; found {
+		return rule
+	}
+	return f.auths["*"]
+}
@@ -3,34 +3,74 @@ package fetcher
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/twiny/wbot/pkg/api"
 )
 
+const (
+	defaultMaxRedirects = 10
+)
+
 type (
 	defaultHTTPClient struct {
-		client     *http.Client
-		bufferPool *sync.Pool
+		client      *http.Client
+		bufferPool  *sync.Pool
+		cache       api.HTTPCache
+		serveCached bool
+
+		// one transport per proxy, to reuse its connections.
+		mu         *sync.Mutex
+		transports map[string]*http.Transport
+
+		downloads         bool
+		downloadDir       string
+		downloadThreshold int64
+		maxDownloadSize   int64
+		bodyWriter        func(req *api.Request, header http.Header) (io.Writer, error)
+
+		isolation JarIsolation
+		cookies   map[string][]*http.Cookie
+		jars      map[string]http.CookieJar
+		logins    []*api.Login
+		sessions  map[string]*session
+
+		headers map[string]*api.HeaderRule
+		auths   map[string]*api.AuthRule
 	}
+
+	Option func(*defaultHTTPClient)
 )
 
-func NewHTTPClient() api.Fetcher {
+// WithCache sends conditional requests using the validators stored in
+// cache. when serveCached is set, the cached body is kept and returned
+// on 304 Not Modified.
+func WithCache(cache api.HTTPCache, serveCached bool) Option {
+	return func(f *defaultHTTPClient) {
+		f.cache = cache
+		f.serveCached = serveCached
+	}
+}
+
+func NewHTTPClient(opts ...Option) api.Fetcher {
 	var (
 		fn = func() any {
 			return new(bytes.Buffer)
 		}
 	)
 
-	return &defaultHTTPClient{
+	f := &defaultHTTPClient{
 		client: &http.Client{
-			Jar:     http.DefaultClient.Jar,
-			Timeout: 10 * time.Second,
 			Transport: &http.Transport{
 				DialContext: (&net.Dialer{
 					Timeout:   10 * time.Second,
@@ -49,121 +89,378 @@ func NewHTTPClient() api.Fetcher {
 		bufferPool: &sync.Pool{
 			New: fn,
 		},
+		mu:         new(sync.Mutex),
+		transports: make(map[string]*http.Transport),
+		jars:       make(map[string]http.CookieJar),
+		sessions:   make(map[string]*session),
+	}
+
+	for _, opt := range opts {
+		opt(f)
 	}
+
+	return f
 }
 
 func (f *defaultHTTPClient) Fetch(ctx context.Context, req *api.Request) (*api.Response, error) {
-	var (
-		respCh   = make(chan *api.Response, 1)
-		fetchErr error
-	)
+	type result struct {
+		resp *api.Response
+		err  error
+	}
 
 	fctx, done := context.WithTimeout(ctx, req.Param.Timeout)
 	defer done()
 
+	resultCh := make(chan result, 1)
 	go func() {
-		resp, err := f.fetch(req)
-		if err != nil {
-			fetchErr = err
-			return
-		}
-		respCh <- resp
+		resp, err := f.fetch(fctx, req)
+		resultCh <- result{resp, err}
 	}()
 
-	for {
-		select {
-		case <-fctx.Done():
-			return nil, fctx.Err()
-		case resp := <-respCh:
-			if fetchErr != nil {
-				return nil, fetchErr
+	select {
+	case <-fctx.Done():
+		// a body downloaded as the fetch timed out is not returned.
+		go func() {
+			if r := <-resultCh; r.resp != nil && r.resp.BodyFile != "" {
+				os.Remove(r.resp.BodyFile)
 			}
-			return resp, nil
-		}
+		}()
+		return nil, fctx.Err()
+	case r := <-resultCh:
+		return r.resp, r.err
 	}
 }
 func (f *defaultHTTPClient) Close() error {
 	f.client.CloseIdleConnections()
+
+	f.mu.Lock()
+	for _, transport := range f.transports {
+		transport.CloseIdleConnections()
+	}
+	f.mu.Unlock()
+
+	if f.cache != nil {
+		return f.cache.Close()
+	}
 	return nil
 }
 
-func (f *defaultHTTPClient) fetch(req *api.Request) (*api.Response, error) {
+func (f *defaultHTTPClient) fetch(ctx context.Context, req *api.Request) (*api.Response, error) {
+	client := *f.client
+	if req.Param.Proxy != "" {
+		transport, err := f.transport(req.Param.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		client.Transport = transport
+	}
+	client.Jar = f.jar(req)
+
+	// the session of the host is logged in before its first request,
+	// and once more when a response shows it expired.
+	session := f.session(req)
+	if session == nil {
+		return f.do(ctx, client, req)
+	}
+
+	gen, err := session.ensure(ctx, client, req.Param.UserAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := f.do(ctx, client, req)
+	if err != nil || !session.login.IsExpired(resp) {
+		return resp, err
+	}
+
+	if _, err := session.renew(ctx, client, req.Param.UserAgent, gen); err != nil {
+		return nil, err
+	}
+
+	return f.do(ctx, client, req)
+}
+func (f *defaultHTTPClient) do(ctx context.Context, client http.Client, req *api.Request) (*api.Response, error) {
 	var header = make(http.Header)
 	header.Set("User-Agent", req.Param.UserAgent)
 	header.Set("Referer", req.Param.Referer)
 
-	if req.Param.Proxy != "" {
-		f.client.Transport = newHTTPTransport(req.Param.Proxy)
+	method := req.HTTPMethod()
+	if len(req.Body) > 0 && req.ContentType != "" {
+		header.Set("Content-Type", req.ContentType)
+	}
+
+	key := req.Target.URL.String()
+
+	// only GET requests are cached, a failing cache only
+	// disables the conditional request.
+	cacheable := f.cache != nil && method == http.MethodGet
+
+	var entry *api.CacheEntry
+	if cacheable {
+		entry, _ = f.cache.Get(ctx, key)
+	}
+
+	if entry != nil {
+		if entry.ETag != "" {
+			header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	var (
+		redirects []*api.Redirect
+		changed   http.Header
+	)
+
+	client.CheckRedirect = func(r *http.Request, via []*http.Request) error {
+		redirects = append(redirects, &api.Redirect{
+			URL:      via[len(via)-1].URL.String(),
+			Location: r.URL.String(),
+			Status:   r.Response.StatusCode,
+		})
+
+		if err := f.reauthorize(r, changed, req); err != nil {
+			return err
+		}
+
+		if req.Param.CheckRedirect != nil {
+			return req.Param.CheckRedirect(r.URL, len(via))
+		}
+
+		if len(via) > defaultMaxRedirects {
+			return fmt.Errorf("stopped after %d redirects", defaultMaxRedirects)
+		}
+		return nil
 	}
 
-	resp, err := f.client.Do(&http.Request{
-		Method:     http.MethodGet,
-		URL:        req.Target.URL,
-		Header:     header,
-		Proto:      "HTTP/1.1",
-		ProtoMajor: 1,
-		ProtoMinor: 1,
-	})
+	start := time.Now()
+
+	// the body can be sent again on 307 and 308 redirects.
+	var reqBody io.Reader
+	if len(req.Body) > 0 {
+		reqBody = bytes.NewReader(req.Body)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, req.Target.URL.String(), reqBody)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	httpReq.Header = header
 
-	buf := f.bufferPool.Get().(*bytes.Buffer)
-	buf.Reset()
-	defer f.bufferPool.Put(buf)
+	changed, err = f.authorize(httpReq, req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-	// Limit response body reading
-	if _, err := io.CopyN(buf, resp.Body, req.Param.MaxBodySize); err != nil && err != io.EOF {
+	body, err := f.readBody(req, resp)
+	if err != nil {
 		return nil, err
 	}
 
-	bytes := buf.Bytes()
+	bytes := body.data
+	elapsed := time.Since(start)
+
+	// links are relative to the page the server redirected to.
+	page := *req
+	if resp.Request != nil && resp.Request.URL.String() != req.Target.URL.String() {
+		if finalURL, err := api.NewURL(resp.Request.URL.String()); err == nil {
+			page.Target = finalURL
+		}
+	}
+
+	notModified := resp.StatusCode == http.StatusNotModified && entry != nil
+	switch {
+	case notModified && f.serveCached:
+		bytes = slices.Clone(entry.Body)
+
+		// a 304 only carries the headers that changed.
+		for key, values := range entry.Header {
+			if _, found := resp.Header[key]; !found {
+				resp.Header[key] = values
+			}
+		}
+	case resp.StatusCode >= 200 && resp.StatusCode < 300 && cacheable && !body.streamed:
+		f.store(ctx, key, resp.Header, bytes)
+	}
+
+	// a streamed text body is parsed from its first bytes.
+	parsed := bytes
+	if body.streamed {
+		parsed = body.prefix
+	}
+
+	// pages are parsed in UTF-8, whatever their charset.
+	text, charset := api.DecodeBody(parsed, resp.Header.Get("Content-Type"))
 
-	links := api.FindLinks(bytes)
+	robots := api.ParseRobotsDirectives(resp.Header, text, req.Param.UserAgent)
+
+	links, canonical := api.ParseLinks(text)
+	if canonical == "" {
+		canonical = headerCanonical(resp.Header)
+	}
+
+	if req.Param.FollowRobots && robots.NoFollow {
+		links = nil
+	}
 
 	var nextURLs []*api.ParsedURL
 	for _, link := range links {
-		absURL, err := req.ResolveURL(link)
-		if err != nil {
+		if req.Param.SkipNofollowLinks && link.HasRel("nofollow", "ugc", "sponsored") {
 			continue
 		}
-		parsedURL, err := api.NewURL(absURL.String())
+
+		parsedURL, err := resolve(&page, link.Href)
 		if err != nil {
 			continue
 		}
 		nextURLs = append(nextURLs, parsedURL)
 	}
 
+	var canonicalURL *api.ParsedURL
+	if canonical != "" {
+		canonicalURL, _ = resolve(&page, canonical)
+	}
+
+	// the body of a streamed response is only in its file or writer.
+	utf8Body := text
+	if body.streamed {
+		utf8Body = nil
+	}
+
 	return &api.Response{
-		URL:      req.Target,
-		Status:   resp.StatusCode,
-		Body:     bytes,
-		NextURLs: nextURLs,
-		Depth:    req.Depth,
+		URL:         req.Target,
+		FinalURL:    page.Target,
+		Status:      resp.StatusCode,
+		Proto:       resp.Proto,
+		Header:      resp.Header,
+		ContentType: contentType(resp.Header),
+		Charset:     charset,
+		Body:        bytes,
+		UTF8Body:    utf8Body,
+		BodySize:    body.size,
+		BodyFile:    body.file,
+		Truncated:   body.truncated,
+		Redirects:   redirects,
+		NextURLs:    nextURLs,
+		Canonical:   canonicalURL,
+		Robots:      robots,
+		Depth:       req.Depth,
+		ElapsedTime: elapsed,
+
+		NotModified: notModified,
 	}, nil
 }
-func newHTTPTransport(purl string) *http.Transport {
-	var proxy = http.ProxyFromEnvironment
 
-	if purl != "" {
-		proxy = func(req *http.Request) (*url.URL, error) {
-			return url.Parse(purl)
+// store saves the validators of a response, and a copy of its body
+// when cached bodies are served. responses without validators are skipped.
+func (f *defaultHTTPClient) store(ctx context.Context, key string, header http.Header, body []byte) {
+	entry := &api.CacheEntry{
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		Header:       header.Clone(),
+		StoredAt:     time.Now(),
+	}
+
+	if entry.ETag == "" && entry.LastModified == "" {
+		return
+	}
+
+	if f.serveCached {
+		entry.Body = slices.Clone(body)
+	}
+
+	f.cache.Put(ctx, key, entry)
+}
+func resolve(req *api.Request, link string) (*api.ParsedURL, error) {
+	absURL, err := req.ResolveURL(link)
+	if err != nil {
+		return nil, err
+	}
+	return api.NewURL(absURL.String())
+}
+
+func contentType(header http.Header) string {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// headerCanonical returns the canonical URL from a Link header,
+// e.g. `<https://example.com/page>; rel="canonical"`.
+func headerCanonical(header http.Header) string {
+	for _, value := range header.Values("Link") {
+		for _, link := range strings.Split(value, ",") {
+			parts := strings.Split(link, ";")
+			href := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(href, "<") || !strings.HasSuffix(href, ">") {
+				continue
+			}
+
+			for _, param := range parts[1:] {
+				key, val, found := strings.Cut(strings.TrimSpace(param), "=")
+				if !found || !strings.EqualFold(key, "rel") {
+					continue
+				}
+
+				for _, rel := range strings.Fields(strings.ToLower(strings.Trim(val, `"`))) {
+					if rel == "canonical" {
+						return href[1 : len(href)-1]
+					}
+				}
+			}
 		}
 	}
+	return ""
+}
+
+// transport returns the transport of a http, https or socks5 proxy,
+// credentials are taken from the proxy URL.
+func (f *defaultHTTPClient) transport(proxy string) (*http.Transport, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if transport, found := f.transports[proxy]; found {
+		return transport, nil
+	}
+
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy: %w", err)
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %q", proxyURL.Scheme)
+	}
+
+	transport := newHTTPTransport(proxyURL)
+	f.transports[proxy] = transport
+
+	return transport, nil
+}
+func newHTTPTransport(proxyURL *url.URL) *http.Transport {
 	return &http.Transport{
-		Proxy: proxy,
+		Proxy: http.ProxyURL(proxyURL),
 		DialContext: (&net.Dialer{
 			Timeout:   10 * time.Second,
 			KeepAlive: 10 * time.Second,
-			DualStack: true,
 		}).DialContext,
-		ForceAttemptHTTP2:     false,
-		MaxIdleConns:          10, // Default: 100
-		MaxIdleConnsPerHost:   5,  // Default: 2
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100, // Default: 100
+		MaxIdleConnsPerHost:   2,   // Default: 2
 		IdleConnTimeout:       10 * time.Second,
-		TLSHandshakeTimeout:   2 * time.Second,
-		ExpectContinueTimeout: 2 * time.Second,
-		DisableKeepAlives:     true,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
 	}
 }
@@ -0,0 +1,262 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/weppos/publicsuffix-go/publicsuffix"
+
+	"github.com/twiny/wbot/pkg/api"
+)
+
+const (
+	// SharedJar keeps the cookies of all hosts in one jar.
+	SharedJar JarIsolation = iota
+	// JarPerHost gives each host its own jar.
+	JarPerHost
+	// JarPerProxy gives each proxy identity its own jar,
+	// requests without proxy share one jar.
+	JarPerProxy
+)
+
+const (
+	maxLoginBodySize = int64(1024 * 1024) // 1MB
+)
+
+type (
+	JarIsolation int
+
+	// session is the login state of a host in a cookie jar,
+	// gen counts the logins to renew an expired session once.
+	session struct {
+		mu       *sync.Mutex
+		login    *api.Login
+		loggedIn bool
+		gen      int
+	}
+)
+
+func WithCookieJar(isolation JarIsolation) Option {
+	return func(f *defaultHTTPClient) {
+		f.isolation = isolation
+	}
+}
+
+// WithCookies seeds every cookie jar with cookies for rawURL.
+func WithCookies(rawURL string, cookies ...*http.Cookie) Option {
+	return func(f *defaultHTTPClient) {
+		if f.cookies == nil {
+			f.cookies = make(map[string][]*http.Cookie)
+		}
+		f.cookies[rawURL] = append(f.cookies[rawURL], cookies...)
+	}
+}
+
+// WithLogin logs in each host of logins before crawling it.
+func WithLogin(logins ...*api.Login) Option {
+	return func(f *defaultHTTPClient) {
+		f.logins = append(f.logins, logins...)
+	}
+}
+
+func (f *defaultHTTPClient) jar(req *api.Request) http.CookieJar {
+	key := f.jarKey(req)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if jar, found := f.jars[key]; found {
+		return jar
+	}
+
+	jar, _ := cookiejar.New(&cookiejar.Options{
+		PublicSuffixList: publicsuffix.CookieJarList,
+	})
+
+	for rawURL, cookies := range f.cookies {
+		if u, err := url.Parse(rawURL); err == nil {
+			jar.SetCookies(u, cookies)
+		}
+	}
+
+	f.jars[key] = jar
+
+	return jar
+}
+
+func (f *defaultHTTPClient) jarKey(req *api.Request) string {
+	switch f.isolation {
+	case JarPerHost:
+		return req.Target.URL.Hostname()
+	case JarPerProxy:
+		return req.Param.Proxy
+	}
+	return ""
+}
+
+// session returns the login session of the host of req in its jar,
+// or nil when the host has no login.
+func (f *defaultHTTPClient) session(req *api.Request) *session {
+	host := req.Target.URL.Hostname()
+
+	var login *api.Login
+	for _, l := range f.logins {
+		if strings.EqualFold(l.Host, host) {
+			login = l
+			break
+		}
+	}
+
+	if login == nil {
+		return nil
+	}
+
+	key := f.jarKey(req) + " " + host
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, found := f.sessions[key]
+	if !found {
+		s = &session{
+			mu:    new(sync.Mutex),
+			login: login,
+		}
+		f.sessions[key] = s
+	}
+
+	return s
+}
+
+// ensure logs in once, the other requests of the host wait for it.
+func (s *session) ensure(ctx context.Context, client http.Client, userAgent string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.loggedIn {
+		return s.gen, nil
+	}
+
+	if err := s.submit(ctx, client, userAgent); err != nil {
+		return s.gen, err
+	}
+
+	s.loggedIn = true
+	s.gen++
+
+	return s.gen, nil
+}
+
+// renew logs in again, unless another request already did
+// since the login gen.
+func (s *session) renew(ctx context.Context, client http.Client, userAgent string, gen int) (int, error) {
+	s.mu.Lock()
+	if s.gen == gen {
+		s.loggedIn = false
+	}
+	s.mu.Unlock()
+
+	return s.ensure(ctx, client, userAgent)
+}
+func (s *session) submit(ctx context.Context, client http.Client, userAgent string) error {
+	login := s.login
+
+	form := url.Values{}
+	for key, values := range login.Form {
+		form[key] = values
+	}
+
+	target := login.URL
+	if login.Page != "" {
+		page, err := send(ctx, client, http.MethodGet, login.Page, userAgent, nil, nil)
+		if err != nil {
+			return fmt.Errorf("login page: %w", err)
+		}
+
+		action, fields := api.ParseForm(page.Body)
+		for key, values := range fields {
+			if _, found := form[key]; !found {
+				form[key] = values
+			}
+		}
+
+		if target == "" {
+			u, err := page.URL.URL.Parse(action)
+			if err != nil {
+				return fmt.Errorf("login form action: %w", err)
+			}
+			target = u.String()
+		}
+	}
+
+	header := login.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	body := login.Body
+	if body != nil {
+		if login.ContentType != "" {
+			header.Set("Content-Type", login.ContentType)
+		}
+	} else {
+		body = []byte(form.Encode())
+		header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	method := login.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	resp, err := send(ctx, client, method, target, userAgent, header, body)
+	if err != nil {
+		return fmt.Errorf("login: %w", err)
+	}
+
+	if !login.Succeeded(resp) {
+		return fmt.Errorf("login: failed with status %d", resp.Status)
+	}
+
+	return nil
+}
+
+// send makes a login request, following redirects.
+func send(ctx context.Context, client http.Client, method, target, userAgent string, header http.Header, body []byte) (*api.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	client.CheckRedirect = nil
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxLoginBodySize))
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.Response{
+		URL:    &api.ParsedURL{URL: resp.Request.URL},
+		Status: resp.StatusCode,
+		Header: resp.Header,
+		Body:   data,
+	}, nil
+}
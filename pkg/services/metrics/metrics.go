@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"encoding/json"
+	"io"
 	"sync/atomic"
 )
 
@@ -53,3 +55,22 @@ func (m *metricsMonitor) Metrics() map[string]int64 {
 		"duplicated_link":     atomic.LoadInt64(&m.duplicatedLink),
 	}
 }
+func (m *metricsMonitor) Checkpoint(w io.Writer) error {
+	return json.NewEncoder(w).Encode(m.Metrics())
+}
+func (m *metricsMonitor) Restore(r io.Reader) error {
+	var values map[string]int64
+	if err := json.NewDecoder(r).Decode(&values); err != nil {
+		return err
+	}
+
+	atomic.StoreInt64(&m.totalRequests, values["total_requests"])
+	atomic.StoreInt64(&m.successfulRequests, values["successful_requests"])
+	atomic.StoreInt64(&m.failedRequests, values["failed_requests"])
+	atomic.StoreInt64(&m.totalLink, values["total_link"])
+	atomic.StoreInt64(&m.crawledLink, values["crawled_link"])
+	atomic.StoreInt64(&m.skippedLink, values["skipped_link"])
+	atomic.StoreInt64(&m.duplicatedLink, values["duplicated_link"])
+
+	return nil
+}
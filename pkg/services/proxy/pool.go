@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/twiny/wbot/pkg/api"
+)
+
+const (
+	defaultMaxFailures = 3
+	defaultBenchTime   = time.Minute
+)
+
+const (
+	// RoundRobin cycles through the available proxies.
+	RoundRobin Strategy = iota
+	// LeastFailures picks the available proxy with the fewest failures.
+	LeastFailures
+	// StickyPerHost keeps using the same proxy for a host,
+	// until the proxy is benched.
+	StickyPerHost
+)
+
+/*
+a proxy is benched for benchTime after maxFailures consecutive failures,
+benched proxies are skipped unless every proxy is benched, then the one
+coming back first is used.
+*/
+type (
+	Strategy int
+
+	defaultPool struct {
+		mu          *sync.Mutex
+		strategy    Strategy
+		maxFailures int
+		benchTime   time.Duration
+		proxies     []*proxyState
+		index       map[string]*proxyState
+		sticky      map[string]*proxyState
+		next        int
+	}
+
+	proxyState struct {
+		stats       api.ProxyStats
+		consecutive int
+	}
+
+	Option func(*defaultPool)
+)
+
+func WithStrategy(strategy Strategy) Option {
+	return func(p *defaultPool) {
+		p.strategy = strategy
+	}
+}
+func WithBench(maxFailures int, benchTime time.Duration) Option {
+	return func(p *defaultPool) {
+		if maxFailures > 0 {
+			p.maxFailures = maxFailures
+		}
+		if benchTime > 0 {
+			p.benchTime = benchTime
+		}
+	}
+}
+
+func NewPool(proxies []string, opts ...Option) api.ProxyPool {
+	p := &defaultPool{
+		mu:          new(sync.Mutex),
+		strategy:    RoundRobin,
+		maxFailures: defaultMaxFailures,
+		benchTime:   defaultBenchTime,
+		index:       make(map[string]*proxyState),
+		sticky:      make(map[string]*proxyState),
+	}
+
+	for _, proxy := range proxies {
+		if proxy == "" || p.index[proxy] != nil {
+			continue
+		}
+
+		state := &proxyState{
+			stats: api.ProxyStats{Proxy: proxy},
+		}
+		p.proxies = append(p.proxies, state)
+		p.index[proxy] = state
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *defaultPool) Next(host string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.proxies) == 0 {
+		return ""
+	}
+
+	now := time.Now()
+
+	var state *proxyState
+	switch p.strategy {
+	case LeastFailures:
+		state = p.leastFailures(now)
+	case StickyPerHost:
+		state = p.sticky[host]
+		if state == nil || state.benched(now) {
+			state = p.roundRobin(now)
+			p.sticky[host] = state
+		}
+	default:
+		state = p.roundRobin(now)
+	}
+
+	if state == nil {
+		state = p.soonest()
+	}
+
+	return state.stats.Proxy
+}
+func (p *defaultPool) Report(proxy string, elapsed time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state, found := p.index[proxy]
+	if !found {
+		return
+	}
+
+	if err == nil {
+		state.stats.Successes++
+		state.stats.Latency += (elapsed - state.stats.Latency) / time.Duration(state.stats.Successes)
+		state.consecutive = 0
+		return
+	}
+
+	state.stats.Failures++
+	state.consecutive++
+	if state.consecutive >= p.maxFailures {
+		state.stats.BenchedUntil = time.Now().Add(p.benchTime)
+		state.consecutive = 0
+	}
+}
+func (p *defaultPool) Stats() []*api.ProxyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]*api.ProxyStats, 0, len(p.proxies))
+	for _, state := range p.proxies {
+		s := state.stats
+		stats = append(stats, &s)
+	}
+
+	return stats
+}
+
+func (p *defaultPool) roundRobin(now time.Time) *proxyState {
+	for i := range p.proxies {
+		idx := (p.next + i) % len(p.proxies)
+		if state := p.proxies[idx]; !state.benched(now) {
+			p.next = idx + 1
+			return state
+		}
+	}
+	return nil
+}
+func (p *defaultPool) leastFailures(now time.Time) *proxyState {
+	var best *proxyState
+	for i := range p.proxies {
+		// start after the last pick so ties are spread.
+		state := p.proxies[(p.next+i)%len(p.proxies)]
+		if state.benched(now) {
+			continue
+		}
+		if best == nil || state.stats.Failures < best.stats.Failures {
+			best = state
+		}
+	}
+
+	p.next++
+	return best
+}
+func (p *defaultPool) soonest() *proxyState {
+	best := p.proxies[0]
+	for _, state := range p.proxies[1:] {
+		if state.stats.BenchedUntil.Before(best.stats.BenchedUntil) {
+			best = state
+		}
+	}
+	return best
+}
+
+func (s *proxyState) benched(now time.Time) bool {
+	return now.Before(s.stats.BenchedUntil)
+}
@@ -0,0 +1,34 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/twiny/wbot/pkg/api"
+)
+
+// requests are checkpointed as a stream of JSON documents.
+func encodeRequests(w io.Writer, reqs ...*api.Request) error {
+	enc := json.NewEncoder(w)
+	for _, req := range reqs {
+		if err := enc.Encode(req); err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+	}
+
+	return nil
+}
+func decodeRequests(r io.Reader, fn func(*api.Request)) error {
+	dec := json.NewDecoder(r)
+	for {
+		req := new(api.Request)
+		if err := dec.Decode(req); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("decode request: %w", err)
+		}
+		fn(req)
+	}
+}
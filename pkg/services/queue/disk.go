@@ -0,0 +1,618 @@
+package queue
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/twiny/wbot/pkg/api"
+)
+
+const (
+	defaultSegmentSize = int64(64 << 20) // 64MB
+
+	segmentExt     = ".seg"
+	cursorFilename = "cursor"
+	keepFilename   = "keep"
+
+	// each record is prefixed by its payload length and crc32 checksum.
+	recordHeaderSize = 8
+	cursorSize       = 16
+)
+
+/*
+the disk queue is an append-only log split into fixed size segments.
+requests are appended to the last segment, and read from the segment
+pointed by the cursor. the cursor (segment id, offset) is persisted on
+every pop, so a restarted queue continues where it stopped.
+fully consumed segments are deleted. once checkpointed, they are kept
+until the next checkpoint, so the queue can be rewound to the cursor
+of the last one.
+*/
+type (
+	defaultDiskQueue struct {
+		mu          *sync.Mutex
+		dir         string
+		segmentSize int64
+
+		writer   *os.File
+		writeSeg uint64
+		writeOff int64
+
+		reader  *os.File
+		readSeg uint64
+		readOff int64
+
+		cursor *os.File
+		length int32
+		closed bool
+		notify *notifier
+
+		// failed closes the queue once its log can not be read
+		// past a record.
+		failed error
+
+		// markSeg is the segment of the last checkpoint, the segments
+		// before it are deleted by the next one.
+		marked  bool
+		markSeg uint64
+	}
+)
+
+func NewDiskQueue(dir string, segmentSize int64) (api.Queue, error) {
+	if segmentSize <= 0 {
+		segmentSize = defaultSegmentSize
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create queue dir: %w", err)
+	}
+
+	q := &defaultDiskQueue{
+		mu:          new(sync.Mutex),
+		dir:         dir,
+		segmentSize: segmentSize,
+		notify:      newNotifier(),
+	}
+
+	if err := q.open(); err != nil {
+		q.closeFiles()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func (q *defaultDiskQueue) Push(ctx context.Context, req *api.Request) error {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("encode request: %w", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return api.ErrQueueClosed
+	}
+	if q.failed != nil {
+		return q.failed
+	}
+
+	size := int64(recordHeaderSize + len(payload))
+	if q.writeOff > 0 && q.writeOff+size > q.segmentSize {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+
+	record := make([]byte, size)
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+
+	if _, err := q.writer.WriteAt(record, q.writeOff); err != nil {
+		return fmt.Errorf("write record: %w", err)
+	}
+
+	q.writeOff += size
+	q.length++
+	q.notify.signal()
+
+	return nil
+}
+func (q *defaultDiskQueue) Pop(ctx context.Context) (*api.Request, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, api.ErrQueueClosed
+	}
+	if q.failed != nil {
+		return nil, q.failed
+	}
+
+	for q.length > 0 {
+		payload, err := q.readRecord(q.reader, q.readOff)
+		switch {
+		case err == io.EOF && q.readSeg < q.writeSeg:
+			// segment fully consumed, move to the next one.
+			if err := q.advance(); err != nil {
+				return nil, err
+			}
+			continue
+		case errors.Is(err, api.ErrQueueCorrupt):
+			// skip the record, not to fail every pop after it.
+			q.readOff += int64(recordHeaderSize + len(payload))
+			q.length--
+
+			if err := q.saveCursor(); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("read record: %w", err)
+		case err != nil:
+			q.failed = fmt.Errorf("%w: read record: %w", api.ErrQueueClosed, err)
+			q.notify.close()
+			return nil, q.failed
+		}
+
+		q.readOff += int64(recordHeaderSize + len(payload))
+		q.length--
+
+		if err := q.saveCursor(); err != nil {
+			return nil, err
+		}
+
+		req := new(api.Request)
+		if err := json.Unmarshal(payload, req); err != nil {
+			return nil, fmt.Errorf("%w: decode request: %v", api.ErrQueueCorrupt, err)
+		}
+
+		return req, nil
+	}
+
+	return nil, api.ErrQueueEmpty
+}
+func (q *defaultDiskQueue) PopWait(ctx context.Context) (*api.Request, error) {
+	return popWait(ctx, q, q.notify)
+}
+func (q *defaultDiskQueue) Len() int32 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.length
+}
+func (q *defaultDiskQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	q.notify.close()
+
+	if err := q.writer.Sync(); err != nil {
+		q.closeFiles()
+		return err
+	}
+
+	if err := q.cursor.Sync(); err != nil {
+		q.closeFiles()
+		return err
+	}
+
+	return q.closeFiles()
+}
+
+// Checkpoint flushes pending writes and saves the read cursor. the
+// segments consumed since the previous checkpoint are kept, so it
+// stays valid until this one is committed.
+func (q *defaultDiskQueue) Checkpoint(w io.Writer) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return api.ErrQueueClosed
+	}
+
+	if err := q.writer.Sync(); err != nil {
+		return fmt.Errorf("sync segment: %w", err)
+	}
+
+	if err := q.cursor.Sync(); err != nil {
+		return fmt.Errorf("sync cursor: %w", err)
+	}
+
+	keep := q.readSeg
+	if q.marked {
+		keep = q.markSeg
+	}
+	if err := q.keep(keep); err != nil {
+		return err
+	}
+
+	q.marked = true
+	q.markSeg = q.readSeg
+
+	if _, err := w.Write(encodeCursor(q.readSeg, q.readOff)); err != nil {
+		return fmt.Errorf("write cursor: %w", err)
+	}
+
+	return nil
+}
+
+// Restore rewinds the queue to the cursor of a checkpoint, the
+// requests popped since are handed out again.
+func (q *defaultDiskQueue) Restore(r io.Reader) error {
+	buf := make([]byte, cursorSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		// a checkpoint without cursor has nothing to rewind.
+		if err == io.EOF {
+			return nil
+		}
+		return fmt.Errorf("read cursor: %w", err)
+	}
+	seg, off := decodeCursor(buf)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return api.ErrQueueClosed
+	}
+
+	if seg > q.readSeg || (seg == q.readSeg && off > q.readOff) {
+		return fmt.Errorf("checkpoint cursor is ahead of the queue")
+	}
+
+	// count the requests from the checkpoint cursor to the tail.
+	var (
+		length int32
+		reader *os.File
+	)
+	for id := seg; id <= q.writeSeg; id++ {
+		f := q.writer
+		if id != q.writeSeg {
+			var err error
+			f, err = os.Open(q.segmentPath(id))
+			if err != nil {
+				if reader != nil {
+					reader.Close()
+				}
+				return fmt.Errorf("open segment: %w", err)
+			}
+		}
+
+		var start int64
+		if id == seg {
+			start = off
+		}
+		_, count := q.scan(f, start)
+		length += count
+
+		switch {
+		case id == seg:
+			reader = f
+		case f != q.writer:
+			f.Close()
+		}
+	}
+
+	if q.reader != q.writer {
+		q.reader.Close()
+	}
+
+	q.reader = reader
+	q.readSeg = seg
+	q.readOff = off
+	q.length = length
+	q.marked = true
+	q.markSeg = seg
+
+	if length > 0 {
+		q.notify.signal()
+	}
+
+	return q.saveCursor()
+}
+
+func (q *defaultDiskQueue) open() error {
+	segments, err := q.segments()
+	if err != nil {
+		return err
+	}
+
+	q.cursor, err = os.OpenFile(filepath.Join(q.dir, cursorFilename), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("open cursor: %w", err)
+	}
+
+	buf := make([]byte, cursorSize)
+	n, err := q.cursor.ReadAt(buf, 0)
+	switch {
+	case n == cursorSize:
+		q.readSeg, q.readOff = decodeCursor(buf)
+	case len(segments) > 0:
+		q.readSeg = segments[0]
+	case err != nil && err != io.EOF:
+		return fmt.Errorf("read cursor: %w", err)
+	}
+
+	// segments consumed since the last checkpoint are kept.
+	keep, err := os.ReadFile(filepath.Join(q.dir, keepFilename))
+	switch {
+	case err == nil && len(keep) == 8:
+		q.marked = true
+		q.markSeg = binary.BigEndian.Uint64(keep)
+	case err != nil && !os.IsNotExist(err):
+		return fmt.Errorf("read keep: %w", err)
+	}
+
+	// drop segments that were consumed before the cursor was saved.
+	var live []uint64
+	for _, id := range segments {
+		if id < q.readSeg {
+			if q.marked && id >= q.markSeg {
+				continue
+			}
+			if err := os.Remove(q.segmentPath(id)); err != nil {
+				return fmt.Errorf("remove segment: %w", err)
+			}
+			continue
+		}
+		live = append(live, id)
+	}
+
+	if len(live) == 0 || live[0] != q.readSeg {
+		live = append([]uint64{q.readSeg}, live...)
+		q.readOff = 0
+	}
+
+	// count pending records, and cut any partially written tail.
+	for _, id := range live {
+		f, err := os.OpenFile(q.segmentPath(id), os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return fmt.Errorf("open segment: %w", err)
+		}
+
+		var off int64
+		if id == q.readSeg {
+			off = q.readOff
+		}
+
+		end, count := q.scan(f, off)
+		if err := f.Truncate(end); err != nil {
+			f.Close()
+			return fmt.Errorf("truncate segment: %w", err)
+		}
+
+		q.length += count
+		q.writeSeg = id
+		q.writeOff = end
+
+		last := id == live[len(live)-1]
+		if id == q.readSeg {
+			q.reader = f
+		}
+		if last {
+			q.writer = f
+		}
+		if id != q.readSeg && !last {
+			f.Close()
+		}
+	}
+
+	return q.saveCursor()
+}
+
+// scan counts the records from off, and returns the end of the last
+// valid one. corrupt records followed by a valid one are counted, Pop
+// skips them, the ones at the end are a torn tail.
+func (q *defaultDiskQueue) scan(f *os.File, off int64) (end int64, count int32) {
+	end = off
+
+	var corrupt int32
+	for {
+		payload, err := q.readRecord(f, off)
+		switch {
+		case errors.Is(err, api.ErrQueueCorrupt):
+			corrupt++
+		case err != nil:
+			return end, count
+		default:
+			count += corrupt + 1
+			corrupt = 0
+		}
+
+		off += int64(recordHeaderSize + len(payload))
+		if corrupt == 0 {
+			end = off
+		}
+	}
+}
+
+// readRecord reads the record at off. a record failing its checksum
+// is returned along with ErrQueueCorrupt, so it can be skipped.
+func (q *defaultDiskQueue) readRecord(f *os.File, off int64) ([]byte, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := f.ReadAt(header, off); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	sum := binary.BigEndian.Uint32(header[4:8])
+
+	// a torn header can not claim more than the segment holds.
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if int64(size) > info.Size()-off-recordHeaderSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	payload := make([]byte, size)
+	if _, err := f.ReadAt(payload, off+recordHeaderSize); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	if crc32.ChecksumIEEE(payload) != sum {
+		return payload, fmt.Errorf("%w: checksum mismatch at %d", api.ErrQueueCorrupt, off)
+	}
+
+	return payload, nil
+}
+func (q *defaultDiskQueue) rotate() error {
+	if err := q.writer.Sync(); err != nil {
+		return fmt.Errorf("sync segment: %w", err)
+	}
+
+	if q.writer != q.reader {
+		q.writer.Close()
+	}
+
+	f, err := os.OpenFile(q.segmentPath(q.writeSeg+1), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("create segment: %w", err)
+	}
+
+	q.writer = f
+	q.writeSeg++
+	q.writeOff = 0
+
+	return nil
+}
+func (q *defaultDiskQueue) advance() error {
+	q.reader.Close()
+
+	// checkpointed queues delete their segments on the next checkpoint.
+	if !q.marked {
+		if err := os.Remove(q.segmentPath(q.readSeg)); err != nil {
+			return fmt.Errorf("remove segment: %w", err)
+		}
+	}
+
+	q.readSeg++
+	q.readOff = 0
+
+	if q.readSeg == q.writeSeg {
+		q.reader = q.writer
+		return q.saveCursor()
+	}
+
+	f, err := os.Open(q.segmentPath(q.readSeg))
+	if err != nil {
+		return fmt.Errorf("open segment: %w", err)
+	}
+	q.reader = f
+
+	return q.saveCursor()
+}
+func (q *defaultDiskQueue) saveCursor() error {
+	if _, err := q.cursor.WriteAt(encodeCursor(q.readSeg, q.readOff), 0); err != nil {
+		return fmt.Errorf("write cursor: %w", err)
+	}
+
+	return nil
+}
+
+// keep deletes the segments before id, which are no longer needed
+// by any checkpoint.
+func (q *defaultDiskQueue) keep(id uint64) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, id)
+
+	if err := os.WriteFile(filepath.Join(q.dir, keepFilename), buf, 0o644); err != nil {
+		return fmt.Errorf("write keep: %w", err)
+	}
+
+	segments, err := q.segments()
+	if err != nil {
+		return err
+	}
+
+	for _, seg := range segments {
+		if seg >= id {
+			break
+		}
+		if err := os.Remove(q.segmentPath(seg)); err != nil {
+			return fmt.Errorf("remove segment: %w", err)
+		}
+	}
+
+	return nil
+}
+func (q *defaultDiskQueue) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read queue dir: %w", err)
+	}
+
+	var ids []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
+func (q *defaultDiskQueue) segmentPath(id uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+func (q *defaultDiskQueue) closeFiles() error {
+	var errs []error
+
+	files := []*os.File{q.cursor, q.writer}
+	if q.reader != q.writer {
+		files = append(files, q.reader)
+	}
+
+	for _, f := range files {
+		if f == nil {
+			continue
+		}
+		if err := f.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("close queue: %v", errs)
+	}
+
+	return nil
+}
+
+func encodeCursor(seg uint64, off int64) []byte {
+	buf := make([]byte, cursorSize)
+	binary.BigEndian.PutUint64(buf[0:8], seg)
+	binary.BigEndian.PutUint64(buf[8:16], uint64(off))
+	return buf
+}
+func decodeCursor(buf []byte) (uint64, int64) {
+	return binary.BigEndian.Uint64(buf[0:8]), int64(binary.BigEndian.Uint64(buf[8:16]))
+}
@@ -0,0 +1,324 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/twiny/wbot/pkg/api"
+)
+
+func TestDiskQueue(t *testing.T) {
+	tests := []struct {
+		name        string
+		segmentSize int64
+		push        int
+		pop         int
+		reopen      bool
+		segments    int
+	}{
+		{"single segment", 0, 5, 5, false, 1},
+		{"segment rotation", 256, 20, 20, false, 1},
+		{"partial pop across segments", 256, 20, 7, false, 0},
+		{"reopen", 0, 5, 2, true, 1},
+		{"reopen across segments", 256, 20, 7, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+
+			q := newTestDiskQueue(t, dir, tt.segmentSize)
+			for i := 0; i < tt.push; i++ {
+				if err := q.Push(ctx, newTestRequest(t, i)); err != nil {
+					t.Fatalf("push %d: %v", i, err)
+				}
+			}
+
+			popTestRequests(t, q, 0, tt.pop)
+
+			if tt.reopen {
+				if err := q.Close(); err != nil {
+					t.Fatalf("close: %v", err)
+				}
+				q = newTestDiskQueue(t, dir, tt.segmentSize)
+			}
+
+			if got, want := q.Len(), int32(tt.push-tt.pop); got != want {
+				t.Fatalf("len = %d, want %d", got, want)
+			}
+
+			popTestRequests(t, q, tt.pop, tt.push)
+
+			if _, err := q.Pop(ctx); !errors.Is(err, api.ErrQueueEmpty) {
+				t.Fatalf("pop on empty queue = %v, want %v", err, api.ErrQueueEmpty)
+			}
+
+			segments, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.segments > 0 && len(segments) != tt.segments {
+				t.Fatalf("segments = %d, want %d", len(segments), tt.segments)
+			}
+
+			if err := q.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+		})
+	}
+}
+
+func TestDiskQueueTornTail(t *testing.T) {
+	tests := []struct {
+		name string
+		tail func() []byte
+	}{
+		{"partial header", func() []byte {
+			return []byte{0, 0}
+		}},
+		{"partial payload", func() []byte {
+			header := make([]byte, recordHeaderSize)
+			binary.BigEndian.PutUint32(header[0:4], 100)
+			return append(header, []byte("{\"Target\":")...)
+		}},
+		{"checksum mismatch", func() []byte {
+			payload := []byte("{}")
+			header := make([]byte, recordHeaderSize)
+			binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+			binary.BigEndian.PutUint32(header[4:8], 1)
+			return append(header, payload...)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+
+			q := newTestDiskQueue(t, dir, 0)
+			for i := 0; i < 3; i++ {
+				if err := q.Push(ctx, newTestRequest(t, i)); err != nil {
+					t.Fatalf("push %d: %v", i, err)
+				}
+			}
+			if err := q.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+
+			segments, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+			if err != nil || len(segments) != 1 {
+				t.Fatalf("segments = %v, %v", segments, err)
+			}
+
+			f, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Write(tt.tail()); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			q = newTestDiskQueue(t, dir, 0)
+			defer q.Close()
+
+			if got := q.Len(); got != 3 {
+				t.Fatalf("len = %d, want 3", got)
+			}
+
+			// the tail is cut, so new records follow the valid ones.
+			if err := q.Push(ctx, newTestRequest(t, 3)); err != nil {
+				t.Fatalf("push: %v", err)
+			}
+
+			popTestRequests(t, q, 0, 4)
+		})
+	}
+}
+
+func TestDiskQueueCorrupt(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(record []byte)
+		reopen  bool
+		want    error
+	}{
+		{"checksum mismatch", func(record []byte) {
+			record[recordHeaderSize] ^= 0xff
+		}, false, api.ErrQueueCorrupt},
+		{"checksum mismatch after reopen", func(record []byte) {
+			record[recordHeaderSize] ^= 0xff
+		}, true, api.ErrQueueCorrupt},
+		{"undecodable payload", func(record []byte) {
+			copy(record[recordHeaderSize:], "[]")
+			binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(record[recordHeaderSize:]))
+		}, false, api.ErrQueueCorrupt},
+		{"torn header", func(record []byte) {
+			binary.BigEndian.PutUint32(record[0:4], 0xffffffff)
+		}, false, api.ErrQueueClosed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+
+			q := newTestDiskQueue(t, dir, 0)
+			for i := 0; i < 3; i++ {
+				if err := q.Push(ctx, newTestRequest(t, i)); err != nil {
+					t.Fatalf("push %d: %v", i, err)
+				}
+			}
+
+			if tt.reopen {
+				if err := q.Close(); err != nil {
+					t.Fatalf("close: %v", err)
+				}
+			}
+
+			// the requests are the same size, corrupt the second one.
+			segment := filepath.Join(dir, fmt.Sprintf("%020d%s", 0, segmentExt))
+			data, err := os.ReadFile(segment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			size := len(data) / 3
+			tt.corrupt(data[size : 2*size])
+			if err := os.WriteFile(segment, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.reopen {
+				q = newTestDiskQueue(t, dir, 0)
+			}
+			defer q.Close()
+
+			popTestRequests(t, q, 0, 1)
+
+			if _, err := q.Pop(ctx); !errors.Is(err, tt.want) {
+				t.Fatalf("pop corrupt = %v, want %v", err, tt.want)
+			}
+
+			if tt.want == api.ErrQueueClosed {
+				// the queue can not be read past the record.
+				if _, err := q.Pop(ctx); !errors.Is(err, api.ErrQueueClosed) {
+					t.Fatalf("pop after = %v, want %v", err, api.ErrQueueClosed)
+				}
+				return
+			}
+
+			// the corrupt record is skipped once.
+			popTestRequests(t, q, 2, 3)
+
+			if _, err := q.Pop(ctx); !errors.Is(err, api.ErrQueueEmpty) {
+				t.Fatalf("pop on empty queue = %v, want %v", err, api.ErrQueueEmpty)
+			}
+		})
+	}
+}
+
+func TestDiskQueueCheckpoint(t *testing.T) {
+	tests := []struct {
+		name        string
+		segmentSize int64
+		push        int
+		checkpoint  int
+		pop         int
+		reopen      bool
+	}{
+		{"nothing popped since", 0, 5, 2, 2, false},
+		{"rewind in segment", 0, 5, 1, 4, false},
+		{"rewind across segments", 256, 20, 3, 15, false},
+		{"rewind after reopen", 256, 20, 3, 15, true},
+		{"rewind to start after reopen", 256, 20, 0, 20, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+
+			q := newTestDiskQueue(t, dir, tt.segmentSize)
+			for i := 0; i < tt.push; i++ {
+				if err := q.Push(ctx, newTestRequest(t, i)); err != nil {
+					t.Fatalf("push %d: %v", i, err)
+				}
+			}
+
+			popTestRequests(t, q, 0, tt.checkpoint)
+
+			var cp bytes.Buffer
+			if err := q.(api.Checkpointer).Checkpoint(&cp); err != nil {
+				t.Fatalf("checkpoint: %v", err)
+			}
+
+			popTestRequests(t, q, tt.checkpoint, tt.pop)
+
+			if tt.reopen {
+				if err := q.Close(); err != nil {
+					t.Fatalf("close: %v", err)
+				}
+				q = newTestDiskQueue(t, dir, tt.segmentSize)
+			}
+			defer q.Close()
+
+			if err := q.(api.Checkpointer).Restore(&cp); err != nil {
+				t.Fatalf("restore: %v", err)
+			}
+
+			if got, want := q.Len(), int32(tt.push-tt.checkpoint); got != want {
+				t.Fatalf("len = %d, want %d", got, want)
+			}
+
+			popTestRequests(t, q, tt.checkpoint, tt.push)
+		})
+	}
+}
+
+func newTestDiskQueue(t *testing.T, dir string, segmentSize int64) api.Queue {
+	t.Helper()
+
+	q, err := NewDiskQueue(dir, segmentSize)
+	if err != nil {
+		t.Fatalf("new disk queue: %v", err)
+	}
+
+	return q
+}
+func newTestRequest(t *testing.T, i int) *api.Request {
+	t.Helper()
+
+	target, err := api.NewURL(fmt.Sprintf("https://example.com/%d", i))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &api.Request{
+		Target: target,
+		Param:  &api.Param{},
+	}
+}
+
+// popTestRequests pops the requests numbered from to to, in order.
+func popTestRequests(t *testing.T, q api.Queue, from, to int) {
+	t.Helper()
+
+	for i := from; i < to; i++ {
+		req, err := q.Pop(context.Background())
+		if err != nil {
+			t.Fatalf("pop %d: %v", i, err)
+		}
+
+		if got, want := req.Target.String(), fmt.Sprintf("https://example.com/%d", i); got != want {
+			t.Fatalf("pop %d = %s, want %s", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/twiny/wbot/pkg/api"
+)
+
+/*
+the host queue keeps a sub-queue per host, and hands out requests
+in round-robin order across the hosts that are currently allowed
+to be fetched. a host is allowed again once the interval returned
+for its last popped request has elapsed.
+within a host, requests are ordered by priority.
+*/
+type (
+	defaultHostQueue struct {
+		mu       *sync.Mutex
+		interval func(*api.Request) time.Duration
+
+		hosts  map[string]*hostQueue
+		ring   []string
+		cursor int
+
+		length int32
+		seq    uint64
+		notify *notifier
+		closed bool
+	}
+
+	hostQueue struct {
+		items *priorityHeap
+		next  time.Time
+	}
+
+	// hostState is the checkpoint record of a single host.
+	hostState struct {
+		Host     string         `json:"host"`
+		Next     time.Time      `json:"next"`
+		Requests []*api.Request `json:"requests"`
+	}
+)
+
+func NewHostQueue(size int, interval func(*api.Request) time.Duration) api.Queue {
+	if interval == nil {
+		interval = func(*api.Request) time.Duration { return 0 }
+	}
+
+	return &defaultHostQueue{
+		mu:       new(sync.Mutex),
+		interval: interval,
+		hosts:    make(map[string]*hostQueue, size),
+		notify:   newNotifier(),
+	}
+}
+
+func (q *defaultHostQueue) Push(ctx context.Context, req *api.Request) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return api.ErrQueueClosed
+	}
+
+	host := req.Target.URL.Host
+	hq, found := q.hosts[host]
+	if !found {
+		items := make(priorityHeap, 0, 8)
+		hq = &hostQueue{
+			items: &items,
+		}
+		q.hosts[host] = hq
+		q.ring = append(q.ring, host)
+	}
+
+	q.seq++
+	heap.Push(hq.items, &priorityItem{
+		req: req,
+		seq: q.seq,
+	})
+	q.length++
+	q.notify.signal()
+
+	return nil
+}
+
+// Pop returns the next request whose host is allowed to be fetched.
+func (q *defaultHostQueue) Pop(ctx context.Context) (*api.Request, error) {
+	req, _, err := q.next(time.Now())
+	return req, err
+}
+
+// PopWait waits for a push, or for the earliest host to be allowed.
+func (q *defaultHostQueue) PopWait(ctx context.Context) (*api.Request, error) {
+	for {
+		req, wait, err := q.next(time.Now())
+		if err != api.ErrQueueEmpty {
+			if err == nil && q.Len() > 0 {
+				q.notify.signal()
+			}
+			return req, err
+		}
+
+		if err := q.notify.wait(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+func (q *defaultHostQueue) Len() int32 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.length
+}
+func (q *defaultHostQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	clear(q.hosts)
+	q.ring = q.ring[:0]
+	q.cursor = 0
+	q.length = 0
+	q.notify.close()
+
+	return nil
+}
+func (q *defaultHostQueue) Checkpoint(w io.Writer) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	for _, host := range q.ring {
+		hq := q.hosts[host]
+
+		if err := enc.Encode(&hostState{
+			Host:     host,
+			Next:     hq.next,
+			Requests: hq.items.requests(),
+		}); err != nil {
+			return fmt.Errorf("encode host: %w", err)
+		}
+	}
+
+	return nil
+}
+func (q *defaultHostQueue) Restore(r io.Reader) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	dec := json.NewDecoder(r)
+	for {
+		state := new(hostState)
+		if err := dec.Decode(state); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("decode host: %w", err)
+		}
+
+		hq, found := q.hosts[state.Host]
+		if !found {
+			items := make(priorityHeap, 0, len(state.Requests))
+			hq = &hostQueue{
+				items: &items,
+			}
+			q.hosts[state.Host] = hq
+			q.ring = append(q.ring, state.Host)
+		}
+
+		if state.Next.After(hq.next) {
+			hq.next = state.Next
+		}
+
+		for _, req := range state.Requests {
+			q.seq++
+			heap.Push(hq.items, &priorityItem{
+				req: req,
+				seq: q.seq,
+			})
+			q.length++
+		}
+	}
+}
+
+// next scans the hosts in round-robin order starting from the cursor.
+// it returns either a ready request, or ErrQueueEmpty with how long
+// to wait for the earliest host to be allowed.
+func (q *defaultHostQueue) next(now time.Time) (*api.Request, time.Duration, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, 0, api.ErrQueueClosed
+	}
+
+	if q.length == 0 {
+		return nil, 0, api.ErrQueueEmpty
+	}
+
+	var (
+		wait  time.Duration = -1
+		count               = len(q.ring)
+	)
+
+	for i := 0; i < count && len(q.ring) > 0; i++ {
+		if q.cursor >= len(q.ring) {
+			q.cursor = 0
+		}
+
+		host := q.ring[q.cursor]
+		hq := q.hosts[host]
+
+		if hq.items.Len() == 0 {
+			// drop idle hosts once they no longer constrain pacing.
+			if !now.Before(hq.next) {
+				delete(q.hosts, host)
+				q.ring = append(q.ring[:q.cursor], q.ring[q.cursor+1:]...)
+				continue
+			}
+			q.cursor++
+			continue
+		}
+
+		if now.Before(hq.next) {
+			if d := hq.next.Sub(now); wait < 0 || d < wait {
+				wait = d
+			}
+			q.cursor++
+			continue
+		}
+
+		item := heap.Pop(hq.items).(*priorityItem)
+		hq.next = now.Add(q.interval(item.req))
+		q.length--
+		q.cursor++
+
+		return item.req, 0, nil
+	}
+
+	return nil, wait, api.ErrQueueEmpty
+}
@@ -0,0 +1,155 @@
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/twiny/wbot/pkg/api"
+)
+
+/*
+requests with a higher priority are popped first,
+requests with the same priority are popped in FIFO order.
+*/
+type (
+	defaultPriorityQueue struct {
+		mu     *sync.RWMutex
+		items  *priorityHeap
+		seq    uint64
+		notify *notifier
+		closed bool
+	}
+
+	priorityItem struct {
+		req *api.Request
+		seq uint64
+	}
+
+	priorityHeap []*priorityItem
+)
+
+func NewPriorityQueue(size int) api.Queue {
+	items := make(priorityHeap, 0, size)
+
+	return &defaultPriorityQueue{
+		mu:     new(sync.RWMutex),
+		items:  &items,
+		notify: newNotifier(),
+	}
+}
+
+func (q *defaultPriorityQueue) Push(ctx context.Context, req *api.Request) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return api.ErrQueueClosed
+	}
+
+	q.seq++
+	heap.Push(q.items, &priorityItem{
+		req: req,
+		seq: q.seq,
+	})
+	q.notify.signal()
+
+	return nil
+}
+func (q *defaultPriorityQueue) Pop(ctx context.Context) (*api.Request, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, api.ErrQueueClosed
+	}
+
+	if q.items.Len() == 0 {
+		return nil, api.ErrQueueEmpty
+	}
+
+	item := heap.Pop(q.items).(*priorityItem)
+
+	return item.req, nil
+}
+func (q *defaultPriorityQueue) PopWait(ctx context.Context) (*api.Request, error) {
+	return popWait(ctx, q, q.notify)
+}
+func (q *defaultPriorityQueue) Len() int32 {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return int32(q.items.Len())
+}
+func (q *defaultPriorityQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	clear(*q.items)
+	*q.items = (*q.items)[:0]
+	q.notify.close()
+
+	return nil
+}
+func (q *defaultPriorityQueue) Checkpoint(w io.Writer) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return encodeRequests(w, q.items.requests()...)
+}
+func (q *defaultPriorityQueue) Restore(r io.Reader) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return decodeRequests(r, func(req *api.Request) {
+		q.seq++
+		heap.Push(q.items, &priorityItem{
+			req: req,
+			seq: q.seq,
+		})
+	})
+}
+
+func (h priorityHeap) Len() int {
+	return len(h)
+}
+func (h priorityHeap) Less(i, j int) bool {
+	if h[i].req.Priority == h[j].req.Priority {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].req.Priority > h[j].req.Priority
+}
+func (h priorityHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+func (h *priorityHeap) Push(x any) {
+	*h = append(*h, x.(*priorityItem))
+}
+func (h *priorityHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// requests returns the requests in pop order, without modifying the heap.
+func (h priorityHeap) requests() []*api.Request {
+	items := make([]*priorityItem, len(h))
+	copy(items, h)
+
+	sort.Slice(items, func(i, j int) bool {
+		return priorityHeap(items).Less(i, j)
+	})
+
+	reqs := make([]*api.Request, 0, len(items))
+	for _, item := range items {
+		reqs = append(reqs, item.req)
+	}
+
+	return reqs
+}
@@ -2,7 +2,7 @@ package queue
 
 import (
 	"context"
-	"fmt"
+	"io"
 	"sync"
 
 	"github.com/twiny/wbot/pkg/api"
@@ -13,14 +13,18 @@ read first page add requests to queue
 if request depth is exceeded return
 */
 type defaultInMemoryQueue struct {
-	mu   *sync.RWMutex
-	list []*api.Request
+	mu     *sync.RWMutex
+	list   []*api.Request
+	notify *notifier
+	closed bool
 }
 
 func NewInMemoryQueue(size int) api.Queue {
 	q := &defaultInMemoryQueue{
 		mu:   new(sync.RWMutex),
 		list: make([]*api.Request, 0, size),
+
+		notify: newNotifier(),
 	}
 
 	return q
@@ -30,7 +34,12 @@ func (q *defaultInMemoryQueue) Push(ctx context.Context, req *api.Request) error
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
+	if q.closed {
+		return api.ErrQueueClosed
+	}
+
 	q.list = append(q.list, req)
+	q.notify.signal()
 
 	return nil
 }
@@ -38,8 +47,12 @@ func (q *defaultInMemoryQueue) Pop(ctx context.Context) (*api.Request, error) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
+	if q.closed {
+		return nil, api.ErrQueueClosed
+	}
+
 	if len(q.list) == 0 {
-		return nil, fmt.Errorf("queue is empty")
+		return nil, api.ErrQueueEmpty
 	}
 
 	req := q.list[0]
@@ -47,6 +60,9 @@ func (q *defaultInMemoryQueue) Pop(ctx context.Context) (*api.Request, error) {
 
 	return req, nil
 }
+func (q *defaultInMemoryQueue) PopWait(ctx context.Context) (*api.Request, error) {
+	return popWait(ctx, q, q.notify)
+}
 func (q *defaultInMemoryQueue) Len() int32 {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
@@ -54,6 +70,27 @@ func (q *defaultInMemoryQueue) Len() int32 {
 	return int32(len(q.list))
 }
 func (q *defaultInMemoryQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
 	clear(q.list)
+	q.list = nil
+	q.notify.close()
+
 	return nil
 }
+func (q *defaultInMemoryQueue) Checkpoint(w io.Writer) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return encodeRequests(w, q.list...)
+}
+func (q *defaultInMemoryQueue) Restore(r io.Reader) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return decodeRequests(r, func(req *api.Request) {
+		q.list = append(q.list, req)
+	})
+}
@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/twiny/wbot/pkg/api"
+)
+
+type (
+	// notifier wakes the consumers blocked in PopWait.
+	notifier struct {
+		ready  chan struct{}
+		closed chan struct{}
+		once   *sync.Once
+	}
+)
+
+func newNotifier() *notifier {
+	return &notifier{
+		ready:  make(chan struct{}, 1),
+		closed: make(chan struct{}),
+		once:   new(sync.Once),
+	}
+}
+
+func (n *notifier) signal() {
+	select {
+	case n.ready <- struct{}{}:
+	default:
+	}
+}
+func (n *notifier) close() {
+	n.once.Do(func() {
+		close(n.closed)
+	})
+}
+
+// wait blocks until signaled, d elapsed, the queue is closed or ctx is done.
+// a zero d waits for a signal only.
+func (n *notifier) wait(ctx context.Context, d time.Duration) error {
+	var timeout <-chan time.Time
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-n.closed:
+		return api.ErrQueueClosed
+	case <-n.ready:
+		return nil
+	case <-timeout:
+		return nil
+	}
+}
+
+// popWait retries q.Pop until it returns a request, waiting on n in between.
+func popWait(ctx context.Context, q api.Queue, n *notifier) (*api.Request, error) {
+	for {
+		req, err := q.Pop(ctx)
+		if err != api.ErrQueueEmpty {
+			// only one consumer is woken per signal,
+			// pass it on while there are requests left.
+			if err == nil && q.Len() > 0 {
+				n.signal()
+			}
+			return req, err
+		}
+
+		if err := n.wait(ctx, 0); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package store
 
 import (
+	"bufio"
 	"context"
+	"fmt"
+	"io"
 	"sync"
 
 	"github.com/twiny/wbot/pkg/api"
@@ -31,6 +34,12 @@ func (s *defaultInMemoryStore) HasVisited(ctx context.Context, link *api.ParsedU
 
 	return found, nil
 }
+func (s *defaultInMemoryStore) Visited(ctx context.Context, link *api.ParsedURL) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.table[link.Hash], nil
+}
 func (s *defaultInMemoryStore) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -38,3 +47,29 @@ func (s *defaultInMemoryStore) Close() error {
 	clear(s.table)
 	return nil
 }
+func (s *defaultInMemoryStore) Checkpoint(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for hash := range s.table {
+		if _, err := fmt.Fprintln(bw, hash); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+func (s *defaultInMemoryStore) Restore(r io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if hash := scanner.Text(); hash != "" {
+			s.table[hash] = true
+		}
+	}
+
+	return scanner.Err()
+}
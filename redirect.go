@@ -0,0 +1,127 @@
+package wbot
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/twiny/wbot/pkg/api"
+)
+
+const (
+	defaultMaxRedirects = 10
+)
+
+// withRedirects returns a copy of req whose redirects follow the
+// redirect policy, and the redirect targets its fetch marked as
+// visited. the param of req is shared with its links.
+func (c *Crawler) withRedirects(req *api.Request) (*api.Request, map[string]bool) {
+	marked := make(map[string]bool)
+
+	param := *req.Param
+	param.CheckRedirect = c.checkRedirect(req, marked)
+
+	fetchReq := *req
+	fetchReq.Param = &param
+
+	return &fetchReq, marked
+}
+
+// checkRedirect follows a redirect only if its target passes the same
+// checks as a link. the final URL is marked as visited once fetched,
+// and an origin without cached robots.txt is left to the link path.
+func (c *Crawler) checkRedirect(req *api.Request, marked map[string]bool) func(target *url.URL, hop int) error {
+	policy := c.cfg.redirect
+
+	return func(target *url.URL, hop int) error {
+		if policy.NoFollow || hop > policy.MaxRedirects {
+			return http.ErrUseLastResponse
+		}
+
+		parsed, err := api.NewURL(target.String())
+		if err != nil {
+			return http.ErrUseLastResponse
+		}
+
+		if !c.inScope(req, parsed) && (policy.SameScope || req.ExternalHops+1 > c.cfg.maxExternalHops) {
+			return http.ErrUseLastResponse
+		}
+
+		if allowed, known := c.robot.lookup(parsed, req.Param.UserAgent); !allowed || !known {
+			return http.ErrUseLastResponse
+		}
+
+		if !c.filter.allow(parsed) {
+			return http.ErrUseLastResponse
+		}
+
+		visited, err := c.redirectVisited(parsed, marked)
+		if err != nil {
+			c.logger.Err(err).Msgf("store")
+		}
+		if visited {
+			return http.ErrUseLastResponse
+		}
+
+		return nil
+	}
+}
+
+// redirectVisited reports whether the redirect target u is visited.
+// a store without VisitChecker marks u as it checks it, u is then
+// recorded in marked so the fetch is not its own duplicate.
+func (c *Crawler) redirectVisited(u *api.ParsedURL, marked map[string]bool) (bool, error) {
+	if checker, ok := c.store.(api.VisitChecker); ok {
+		return checker.Visited(c.ctx, u)
+	}
+
+	visited, err := c.store.HasVisited(c.ctx, u)
+	if err == nil && !visited {
+		marked[u.Hash] = true
+	}
+
+	return visited, err
+}
+
+// visitFinal marks the URL req was redirected to as visited,
+// and reports whether it already was.
+func (c *Crawler) visitFinal(req *api.Request, resp *api.Response, marked map[string]bool) bool {
+	if resp.FinalURL == nil || resp.FinalURL.Hash == req.Target.Hash || marked[resp.FinalURL.Hash] {
+		return false
+	}
+
+	visited, err := c.store.HasVisited(c.ctx, resp.FinalURL)
+	if err != nil {
+		c.logger.Err(err).Msgf("store")
+	}
+
+	return visited
+}
+
+// redirect queues the target of a redirect that was not followed.
+func (c *Crawler) redirect(req *api.Request, resp *api.Response) {
+	if resp.Status < 300 || resp.Status >= 400 || resp.NotModified {
+		return
+	}
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return
+	}
+
+	base := req.Target
+	if resp.FinalURL != nil {
+		base = resp.FinalURL
+	}
+
+	u, err := base.URL.Parse(location)
+	if err != nil {
+		return
+	}
+
+	target, err := api.NewURL(u.String())
+	if err != nil {
+		return
+	}
+
+	c.link(req, target, nil)
+}
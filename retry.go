@@ -0,0 +1,145 @@
+package wbot
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/twiny/wbot/pkg/api"
+)
+
+const (
+	defaultRetryAttempts  = 3
+	defaultRetryBaseDelay = 1 * time.Second
+	defaultRetryMaxDelay  = 1 * time.Minute
+)
+
+var (
+	defaultRetryStatusCodes = []int{
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
+)
+
+type (
+	retrier struct {
+		policy *api.RetryPolicy
+	}
+)
+
+func newRetrier(policy *api.RetryPolicy) *retrier {
+	if policy == nil {
+		return &retrier{}
+	}
+
+	p := *policy
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultRetryAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = defaultRetryBaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = defaultRetryMaxDelay
+	}
+	if p.StatusCodes == nil {
+		p.StatusCodes = defaultRetryStatusCodes
+	}
+
+	return &retrier{
+		policy: &p,
+	}
+}
+
+// delay reports whether req should be retried after the fetch
+// returned resp or err, and how long to wait before retrying it.
+func (r *retrier) delay(req *api.Request, resp *api.Response, err error) (time.Duration, bool) {
+	if r.policy == nil || int(req.Attempt)+1 >= r.policy.MaxAttempts {
+		return 0, false
+	}
+
+	switch {
+	case err != nil:
+		if errors.Is(err, context.Canceled) {
+			return 0, false
+		}
+		if r.policy.Retryable != nil && !r.policy.Retryable(err) {
+			return 0, false
+		}
+	case !slices.Contains(r.policy.StatusCodes, resp.Status):
+		return 0, false
+	}
+
+	d := r.policy.BaseDelay << req.Attempt
+	if d <= 0 || d > r.policy.MaxDelay {
+		d = r.policy.MaxDelay
+	}
+
+	if r.policy.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * r.policy.Jitter * float64(d))
+	}
+
+	if d > r.policy.MaxDelay {
+		d = r.policy.MaxDelay
+	}
+
+	// the server is not retried before its Retry-After, nor later than MaxDelay.
+	if resp != nil && (resp.Status == http.StatusTooManyRequests || resp.Status == http.StatusServiceUnavailable) {
+		after := retryAfter(resp.Header)
+		if after > r.policy.MaxDelay {
+			return 0, false
+		}
+		if after > d {
+			d = after
+		}
+	}
+
+	return d, true
+}
+
+// retry queues req again after d, it stays in-flight in the meantime.
+func (c *Crawler) retry(req *api.Request, d time.Duration) {
+	req.Attempt++
+
+	c.logger.Debug().Any("target", req.Target.String()).Int32("attempt", req.Attempt).Dur("delay", d).Msgf("retry")
+
+	time.AfterFunc(d, func() {
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		c.checkpointMu.RLock()
+		defer c.checkpointMu.RUnlock()
+
+		c.untrack(req)
+		if err := c.push(req); err != nil {
+			c.logger.Err(err).Any("target", req.Target.String()).Msgf("push")
+		}
+		c.finish()
+	})
+}
+
+// retryAfter parses a Retry-After header, in seconds or as an HTTP date.
+func retryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+
+	return 0
+}
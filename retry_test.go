@@ -0,0 +1,111 @@
+package wbot
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/twiny/wbot/pkg/api"
+)
+
+func TestRetrierDelay(t *testing.T) {
+	errTimeout := errors.New("timeout")
+
+	policy := &api.RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Second,
+		MaxDelay:    10 * time.Second,
+		Retryable: func(err error) bool {
+			return err == errTimeout
+		},
+	}
+
+	tests := []struct {
+		name    string
+		policy  *api.RetryPolicy
+		attempt int32
+		status  int
+		after   string
+		err     error
+		want    time.Duration
+		retry   bool
+	}{
+		{"no policy", nil, 0, http.StatusServiceUnavailable, "", nil, 0, false},
+		{"success", policy, 0, http.StatusOK, "", nil, 0, false},
+		{"first attempt", policy, 0, http.StatusServiceUnavailable, "", nil, time.Second, true},
+		{"backoff", policy, 1, http.StatusBadGateway, "", nil, 2 * time.Second, true},
+		{"last attempt", policy, 2, http.StatusServiceUnavailable, "", nil, 0, false},
+		{"capped backoff", &api.RetryPolicy{MaxAttempts: 100, MaxDelay: 10 * time.Second}, 40, http.StatusServiceUnavailable, "", nil, 10 * time.Second, true},
+		{"retryable error", policy, 0, 0, "", errTimeout, time.Second, true},
+		{"not retryable error", policy, 0, 0, "", errors.New("tls"), 0, false},
+		{"canceled", policy, 0, 0, "", context.Canceled, 0, false},
+		{"retry after", policy, 0, http.StatusTooManyRequests, "5", nil, 5 * time.Second, true},
+		{"retry after shorter", policy, 1, http.StatusServiceUnavailable, "1", nil, 2 * time.Second, true},
+		{"retry after past max delay", policy, 0, http.StatusTooManyRequests, "60", nil, 0, false},
+		{"retry after ignored", policy, 0, http.StatusInternalServerError, "5", nil, time.Second, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &api.Request{Attempt: tt.attempt}
+
+			var resp *api.Response
+			if tt.err == nil {
+				resp = &api.Response{Status: tt.status, Header: http.Header{}}
+				if tt.after != "" {
+					resp.Header.Set("Retry-After", tt.after)
+				}
+			}
+
+			got, retry := newRetrier(tt.policy).delay(req, resp, tt.err)
+			if got != tt.want || retry != tt.retry {
+				t.Fatalf("delay = %v, %v, want %v, %v", got, retry, tt.want, tt.retry)
+			}
+		})
+	}
+}
+
+func TestRetrierJitter(t *testing.T) {
+	r := newRetrier(&api.RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute, Jitter: 0.5})
+	resp := &api.Response{Status: http.StatusServiceUnavailable, Header: http.Header{}}
+
+	for i := 0; i < 100; i++ {
+		got, retry := r.delay(&api.Request{Attempt: 1}, resp, nil)
+		if !retry || got < time.Second || got > 3*time.Second {
+			t.Fatalf("delay = %v, %v, want within [1s, 3s]", got, retry)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	date := time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name     string
+		value    string
+		min, max time.Duration
+	}{
+		{"missing", "", 0, 0},
+		{"seconds", "120", 2 * time.Minute, 2 * time.Minute},
+		{"zero", "0", 0, 0},
+		{"date", date, 28 * time.Second, 30 * time.Second},
+		{"past date", "Wed, 21 Oct 2015 07:28:00 GMT", time.Duration(math.MinInt64), 0},
+		{"invalid", "soon", 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.value != "" {
+				header.Set("Retry-After", tt.value)
+			}
+
+			if got := retryAfter(header); got < tt.min || got > tt.max {
+				t.Fatalf("retryAfter(%q) = %v, want within [%v, %v]", tt.value, got, tt.min, tt.max)
+			}
+		})
+	}
+}
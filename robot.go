@@ -1,41 +1,210 @@
 package wbot
 
 import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
 	"github.com/temoto/robotstxt"
+
+	"github.com/twiny/wbot/pkg/api"
 )
 
 const (
 	robotstxtPath = "/robots.txt"
+
+	// RFC 9309 asks crawlers not to cache robots.txt for more than 24 hours,
+	// an unreachable robots.txt is retried sooner.
+	robotsTTL      = 24 * time.Hour
+	robotsErrorTTL = 10 * time.Minute
 )
 
-type robotManager struct {
-	followRobots bool
-	robots       map[string]*robotstxt.RobotsData
-}
+type (
+	robotManager struct {
+		mu           *sync.Mutex
+		followRobots bool
+		fetch        func(ctx context.Context, req *api.Request) (*api.Response, error)
+		crawlDelay   func(host string, delay time.Duration)
+		robots       map[string]*robotsEntry
+	}
+
+	// robotsEntry is the cached robots.txt of an origin,
+	// ready is closed once it has been fetched.
+	robotsEntry struct {
+		ready   chan struct{}
+		data    *robotstxt.RobotsData
+		expires time.Time
+	}
+)
 
-func newRobotManager(follow bool) *robotManager {
+func newRobotManager(
+	follow bool,
+	fetch func(ctx context.Context, req *api.Request) (*api.Response, error),
+	crawlDelay func(host string, delay time.Duration),
+) *robotManager {
 	return &robotManager{
+		mu:           new(sync.Mutex),
 		followRobots: follow,
-		robots:       make(map[string]*robotstxt.RobotsData),
+		fetch:        fetch,
+		crawlDelay:   crawlDelay,
+		robots:       make(map[string]*robotsEntry),
 	}
 }
 
-func (rm *robotManager) AddRobotsTxt(hostname string, body []byte) error {
-	data, err := robotstxt.FromBytes(body)
-	if err != nil {
-		return err // Return the error if parsing fails.
+// allowed fetches the robots.txt of the origin of req if needed,
+// and reports whether req may be crawled.
+func (rm *robotManager) allowed(ctx context.Context, req *api.Request) bool {
+	if !rm.followRobots {
+		return true
 	}
 
-	rm.robots[hostname] = data
-	return nil
+	data := rm.get(ctx, req)
+	if data == nil {
+		return true
+	}
+
+	return data.TestAgent(req.Target.URL.RequestURI(), api.ProductToken(req.Param.UserAgent))
 }
-func (rm *robotManager) Allowed(userAgent, url string) bool {
-	hostname := url // Simplification; use proper URL parsing in production.
 
-	robotsData, exists := rm.robots[hostname]
-	if !exists {
-		return true
+// cached reports whether u may be crawled according to the cached
+// robots.txt of its origin only, unknown origins are allowed.
+func (rm *robotManager) cached(u *api.ParsedURL, userAgent string) bool {
+	allowed, _ := rm.lookup(u, userAgent)
+	return allowed
+}
+
+// lookup reports whether u may be crawled according to the cached
+// robots.txt of its origin, known is false when it is not fetched yet.
+func (rm *robotManager) lookup(u *api.ParsedURL, userAgent string) (allowed, known bool) {
+	if !rm.followRobots {
+		return true, true
+	}
+
+	rm.mu.Lock()
+	entry, found := rm.robots[origin(u.URL)]
+	rm.mu.Unlock()
+
+	if !found {
+		return true, false
+	}
+
+	select {
+	case <-entry.ready:
+	default:
+		return true, false
+	}
+
+	if entry.data == nil {
+		return true, true
+	}
+
+	return entry.data.TestAgent(u.URL.RequestURI(), api.ProductToken(userAgent)), true
+}
+func (rm *robotManager) get(ctx context.Context, req *api.Request) *robotstxt.RobotsData {
+	key := origin(req.Target.URL)
+
+	rm.mu.Lock()
+	entry, found := rm.robots[key]
+
+	var prev *robotstxt.RobotsData
+	if found {
+		select {
+		case <-entry.ready:
+			if time.Now().After(entry.expires) {
+				prev = entry.data
+				found = false
+			}
+		default:
+		}
+	}
+
+	// only one worker fetches the robots.txt of an origin,
+	// the others wait for it.
+	if !found {
+		entry = &robotsEntry{
+			ready: make(chan struct{}),
+		}
+		rm.robots[key] = entry
+	}
+	rm.mu.Unlock()
+
+	if found {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-entry.ready:
+			return entry.data
+		}
+	}
+
+	entry.data, entry.expires = rm.load(ctx, req, prev)
+
+	// pace the host before any worker is allowed to fetch it, the
+	// robots.txt fetched for sitemaps alone does not pace the crawl.
+	if entry.data != nil && rm.crawlDelay != nil && rm.followRobots {
+		group := entry.data.FindGroup(api.ProductToken(req.Param.UserAgent))
+		rm.crawlDelay(req.Target.URL.Host, group.CrawlDelay)
 	}
+	close(entry.ready)
+
+	return entry.data
+}
+
+// load fetches and parses a robots.txt following RFC 9309:
+// 4xx means no restrictions, 5xx or an unreachable host means
+// full disallow until it is retried. a robots.txt that was not
+// modified keeps its previous rules.
+func (rm *robotManager) load(ctx context.Context, req *api.Request, prev *robotstxt.RobotsData) (*robotstxt.RobotsData, time.Time) {
+	target, err := api.NewURL(origin(req.Target.URL) + robotstxtPath)
+	if err != nil {
+		return nil, time.Now().Add(robotsTTL)
+	}
+
+	param := *req.Param
+	param.CheckRedirect = nil
+	param.Internal = true
+	resp, err := rm.fetch(ctx, &api.Request{
+		Target: target,
+		Seed:   req.Seed,
+		Param:  &param,
+	})
+
+	status := http.StatusServiceUnavailable
+	var body []byte
+	if err == nil {
+		status = resp.Status
+		if body, err = readBody(resp); err != nil {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	if err == nil && resp.NotModified {
+		if prev != nil && len(body) == 0 {
+			return prev, time.Now().Add(robotsTTL)
+		}
+		status = http.StatusOK
+	}
+
+	// too many requests is handled as a server error.
+	if status == http.StatusTooManyRequests {
+		status = http.StatusServiceUnavailable
+	}
+
+	ttl := robotsTTL
+	if status >= 500 {
+		ttl = robotsErrorTTL
+	}
+
+	data, err := robotstxt.FromStatusAndBytes(status, body)
+	if err != nil {
+		return nil, time.Now().Add(ttl)
+	}
+
+	return data, time.Now().Add(ttl)
+}
 
-	return robotsData.TestAgent(url, userAgent)
+func origin(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
 }
@@ -0,0 +1,180 @@
+package wbot
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/twiny/wbot/pkg/api"
+)
+
+const (
+	sitemapPath = "/sitemap.xml"
+
+	// limits applied to the sitemaps discovered for a single origin.
+	maxSitemapDepth = 3
+	maxSitemaps     = 1000
+)
+
+type (
+	sitemapManager struct {
+		mu      *sync.Mutex
+		enabled bool
+		only    bool
+		origins map[string]bool
+
+		// active counts the origins being discovered.
+		active int64
+	}
+)
+
+func newSitemapManager() *sitemapManager {
+	return &sitemapManager{
+		mu:      new(sync.Mutex),
+		origins: make(map[string]bool),
+	}
+}
+
+// claim reports whether the sitemaps of origin are yet to be discovered.
+func (sm *sitemapManager) claim(origin string) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.origins[origin] {
+		return false
+	}
+	sm.origins[origin] = true
+
+	return true
+}
+
+// discoverSitemaps finds the sitemaps of the origin of seed in the background,
+// the crawl is kept open until they are all queued.
+func (c *Crawler) discoverSitemaps(seed *api.Request) {
+	if !c.sitemap.enabled || !c.sitemap.claim(origin(seed.Target.URL)) {
+		return
+	}
+
+	atomic.AddInt64(&c.pending, 1)
+	atomic.AddInt64(&c.sitemap.active, 1)
+
+	go func() {
+		defer c.finish()
+		defer atomic.AddInt64(&c.sitemap.active, -1)
+
+		c.walkSitemaps(seed)
+	}()
+}
+
+// walkSitemaps fetches the sitemaps listed in robots.txt and /sitemap.xml,
+// following sitemap indexes, and queues the URLs they list.
+func (c *Crawler) walkSitemaps(seed *api.Request) {
+	type sitemapRef struct {
+		loc   string
+		depth int
+	}
+
+	var refs []sitemapRef
+	if data := c.robot.get(c.ctx, seed); data != nil {
+		for _, loc := range data.Sitemaps {
+			refs = append(refs, sitemapRef{loc: loc})
+		}
+	}
+	refs = append(refs, sitemapRef{loc: origin(seed.Target.URL) + sitemapPath})
+
+	var (
+		seen    = make(map[string]bool)
+		fetched int
+	)
+
+	for len(refs) > 0 && fetched < maxSitemaps {
+		ref := refs[0]
+		refs = refs[1:]
+
+		if seen[ref.loc] {
+			continue
+		}
+		seen[ref.loc] = true
+
+		if !c.parked(c.ctx) {
+			return
+		}
+
+		sitemap, err := c.fetchSitemap(seed, ref.loc)
+		if err != nil {
+			c.logger.Debug().Err(err).Str("sitemap", ref.loc).Msgf("sitemap")
+			continue
+		}
+		fetched++
+
+		if ref.depth < maxSitemapDepth {
+			for _, loc := range sitemap.Sitemaps {
+				refs = append(refs, sitemapRef{loc: loc, depth: ref.depth + 1})
+			}
+		}
+
+		c.queueSitemap(seed, ref.loc, sitemap)
+	}
+}
+func (c *Crawler) fetchSitemap(seed *api.Request, loc string) (*api.Sitemap, error) {
+	target, err := api.NewURL(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	param := *seed.Param
+	param.MaxBodySize = api.MaxSitemapSize
+	param.Internal = true
+
+	req := &api.Request{
+		Target: target,
+		Seed:   seed.Seed,
+		Param:  &param,
+	}
+
+	if !c.robot.allowed(c.ctx, req) {
+		return nil, fmt.Errorf("disallowed by robots.txt")
+	}
+
+	resp, err := c.fetch(c.ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status < 200 || resp.Status > 299 {
+		return nil, fmt.Errorf("unexpected status: %d", resp.Status)
+	}
+
+	body, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.ParseSitemap(body)
+}
+func (c *Crawler) queueSitemap(seed *api.Request, loc string, sitemap *api.Sitemap) {
+	c.checkpointMu.RLock()
+	defer c.checkpointMu.RUnlock()
+
+	for _, u := range sitemap.URLs {
+		target, err := api.NewURL(u.Loc)
+		if err != nil {
+			continue
+		}
+
+		meta := map[string]string{
+			"sitemap": loc,
+		}
+		if u.LastMod != "" {
+			meta["sitemap_lastmod"] = u.LastMod
+		}
+		if u.ChangeFreq != "" {
+			meta["sitemap_changefreq"] = u.ChangeFreq
+		}
+		if u.Priority != "" {
+			meta["sitemap_priority"] = u.Priority
+		}
+
+		c.link(seed, target, meta)
+	}
+}